@@ -27,3 +27,35 @@ func BenchmarkEval(b *testing.B) {
 		l.Eval(`return a, b, c`, "a", "foobar", "b", 42, "c", true)
 	}
 }
+
+func BenchmarkEvalCache(b *testing.B) {
+	l, err := New()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+	l.SetEvalCache(16)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		l.Eval(`return a, b, c`, "a", "foobar", "b", 42, "c", true)
+	}
+}
+
+func BenchmarkChunkRun(b *testing.B) {
+	l, err := New()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+	chunk, err := l.Compile(`return a, b, c`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer chunk.Close()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		chunk.Run("a", "foobar", "b", 42, "c", true)
+	}
+}
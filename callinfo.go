@@ -0,0 +1,86 @@
+package lua
+
+/*
+#include <lua.h>
+*/
+import "C"
+import (
+	"fmt"
+	"reflect"
+)
+
+// CallInfo describes the lua code calling a go function.
+// go functions declaring *CallInfo as the first parameter receive it, lua passes arguments to the others.
+// arguments can only be accessed before the go function returns, so async functions can not take it.
+type CallInfo struct {
+	Name        string // name the go function is called by, or the name it's set with
+	Source      string // chunk name of the caller, like @path/to/file.lua
+	ShortSource string // printable version of Source
+	Line        int    // current line of the caller, -1 if not available
+	Function    string // name of the calling function, empty if not known
+	Depth       int    // number of active functions, including the go function
+	lua         *Lua
+}
+
+var callInfoType = reflect.TypeOf((*CallInfo)(nil))
+
+func (l *Lua) callInfo(function *_Function) *CallInfo {
+	info := &CallInfo{
+		Name: function.name,
+		Line: -1,
+		lua:  l,
+	}
+	var ar C.lua_Debug
+	for level := C.int(0); C.lua_getstack(l.State, level, &ar) != 0; level++ {
+		info.Depth++
+		switch level {
+		case 0: // the go function
+			C.lua_getinfo(l.State, cstr("n"), &ar)
+			if ar.name != nil {
+				info.Name = C.GoString(ar.name)
+			}
+		case 1: // the caller
+			C.lua_getinfo(l.State, cstr("nSl"), &ar)
+			info.Source = C.GoString(ar.source)
+			info.ShortSource = C.GoString(&ar.short_src[0])
+			info.Line = int(ar.currentline)
+			if ar.name != nil {
+				info.Function = C.GoString(ar.name)
+			}
+		}
+	}
+	return info
+}
+
+// NumArgs returns the number of arguments passed by lua
+func (c *CallInfo) NumArgs() int {
+	return int(C.lua_gettop(c.lua.State))
+}
+
+// ArgType returns the lua type name of the ith argument, starting from 1. returns "none" if not passed.
+func (c *CallInfo) ArgType(i int) string {
+	if i < 1 || i > c.NumArgs() {
+		return "none"
+	}
+	return C.GoString(C.lua_typename(c.lua.State, C.lua_type(c.lua.State, C.int(i))))
+}
+
+// Arg returns the ith argument, starting from 1, converted like Get. returns nil if not passed.
+func (c *CallInfo) Arg(i int) (interface{}, error) {
+	if i < 1 || i > c.NumArgs() {
+		return nil, nil
+	}
+	value, err := c.lua.toGoValue(C.int(i), interfaceType)
+	if err != nil {
+		return nil, fmt.Errorf("argument %d: %v", i, err)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	return value.Interface(), nil
+}
+
+// Location returns the caller's position like short_src:line
+func (c *CallInfo) Location() string {
+	return fmt.Sprintf("%s:%d", c.ShortSource, c.Line)
+}
@@ -0,0 +1,78 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestCallInfo(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var info CallInfo
+	var args []interface{}
+	var types []string
+	l.Set("log", func(c *CallInfo, msg string, level int) string {
+		info = *c
+		args = nil
+		types = nil
+		for i := 1; i <= c.NumArgs()+1; i++ {
+			arg, err := c.Arg(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			args = append(args, arg)
+			types = append(types, c.ArgType(i))
+		}
+		return c.Location() + ": " + msg
+	})
+	chunk, err := l.LoadString("scripts/callinfo.lua", `
+local function handle()
+  local ret = log('hello', 2)
+  return ret
+end
+function run()
+  local ret = handle()
+  return ret
+end
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chunk.Close()
+	if _, err := chunk.Run(); err != nil {
+		t.Fatal(err)
+	}
+	ret, err := l.Pcall("run")
+	if err != nil || ret[0] != "scripts/callinfo.lua:3: hello" {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	if info.Name != "log" || info.Source != "@scripts/callinfo.lua" ||
+		info.ShortSource != "scripts/callinfo.lua" || info.Line != 3 ||
+		info.Function != "handle" || info.Depth < 3 {
+		t.Fatalf("bad info %+v", info)
+	}
+	if len(args) != 3 || args[0] != "hello" || args[1] != 2.0 || args[2] != nil {
+		t.Fatalf("bad args %v", args)
+	}
+	if len(types) != 3 || types[0] != "string" || types[1] != "number" || types[2] != "none" {
+		t.Fatalf("bad types %v", types)
+	}
+
+	// argument number check excludes *CallInfo
+	if _, err := l.Peval(`log('foo')`); err == nil {
+		t.Fatalf("should fail")
+	}
+	// only *CallInfo
+	l.Set("where", func(c *CallInfo) int {
+		return c.Line
+	})
+	ret, err = l.Peval(`
+	local line = where()
+	return line`)
+	if err != nil || ret[0].(float64) != 2 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+}
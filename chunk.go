@@ -0,0 +1,214 @@
+package lua
+
+/*
+#include <lua.h>
+#include <lauxlib.h>
+
+#include <stdlib.h>
+
+void push_errfunc(lua_State*);
+int dump_function(lua_State*, char**, size_t*);
+void refuse_binary_loaders(lua_State*, int);
+*/
+import "C"
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"unsafe"
+)
+
+// Chunk is a compiled piece of lua code
+type Chunk struct {
+	lua  *Lua
+	name string
+	ref  C.int
+}
+
+// LoadString compiles code without running it. name is used in error messages and tracebacks.
+func (l *Lua) LoadString(name, code string) (*Chunk, error) {
+	return l.loadChunk(name, "@"+name, []byte(code))
+}
+
+// Compile compiles code without running it. errors are reported like Peval.
+func (l *Lua) Compile(code string) (*Chunk, error) {
+	return l.loadChunk(code, code, []byte(code))
+}
+
+// LoadBytecode loads a chunk dumped by Chunk.Dump
+func (l *Lua) LoadBytecode(name string, code []byte) (*Chunk, error) {
+	if !isBinaryChunk(code) {
+		return nil, fmt.Errorf("LOAD ERROR: not a binary chunk: %s", name)
+	}
+	return l.loadChunk(name, "@"+name, code)
+}
+
+// RefuseBinaryChunks makes chunks loaded from go fail if they are precompiled bytecode.
+// it's for running untrusted code. the global load, loadstring, loadfile and dofile are also
+// replaced by ones refusing bytecode, and restored when refuse is false.
+// references to the originals taken before, and require of files in package.path, are not guarded.
+func (l *Lua) RefuseBinaryChunks(refuse bool) {
+	defer C.lua_settop(l.State, 0)
+	l.refuseBinary = refuse
+	flag := C.int(0)
+	if refuse {
+		flag = 1
+	}
+	C.refuse_binary_loaders(l.State, flag)
+}
+
+func isBinaryChunk(code []byte) bool {
+	return len(code) > 0 && code[0] == C.LUA_SIGNATURE[0]
+}
+
+// DoFile runs a lua file
+func (l *Lua) DoFile(path string, envs ...interface{}) ([]interface{}, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return l.do(path, code, envs)
+}
+
+// DoReader runs lua code read from r. name is used in error messages and tracebacks.
+func (l *Lua) DoReader(name string, r io.Reader, envs ...interface{}) ([]interface{}, error) {
+	code, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return l.do(name, code, envs)
+}
+
+func (l *Lua) do(name string, code []byte, envs []interface{}) ([]interface{}, error) {
+	defer C.lua_settop(l.State, 0)
+	C.push_errfunc(l.State)
+	curTop := C.lua_gettop(l.State)
+	if err := l.load("@"+name, code); err != nil {
+		return nil, err
+	}
+	return l.run(curTop, envs)
+}
+
+func (l *Lua) loadChunk(name, chunkname string, code []byte) (*Chunk, error) {
+	defer C.lua_settop(l.State, 0)
+	if err := l.load(chunkname, code); err != nil {
+		return nil, err
+	}
+	return &Chunk{
+		lua:  l,
+		name: name,
+		ref:  C.luaL_ref(l.State, C.LUA_REGISTRYINDEX),
+	}, nil
+}
+
+// Name returns the chunk name
+func (c *Chunk) Name() string {
+	return c.name
+}
+
+// Run runs the chunk. envs are name-value pairs like in Peval.
+func (c *Chunk) Run(envs ...interface{}) ([]interface{}, error) {
+	l := c.lua
+	defer C.lua_settop(l.State, 0)
+	C.push_errfunc(l.State)
+	curTop := C.lua_gettop(l.State)
+	C.lua_rawgeti(l.State, C.LUA_REGISTRYINDEX, c.ref)
+	// reset env set by last run
+	C.lua_pushvalue(l.State, C.LUA_GLOBALSINDEX)
+	C.lua_setfenv(l.State, -2)
+	return l.run(curTop, envs)
+}
+
+// RunInspect runs the chunk like Run, and renders the returned values like Inspect.
+// tables and functions are returned as they are seen by lua, instead of converted to go values.
+func (c *Chunk) RunInspect(depth int, envs ...interface{}) ([]string, error) {
+	l := c.lua
+	defer C.lua_settop(l.State, 0)
+	C.push_errfunc(l.State)
+	curTop := C.lua_gettop(l.State)
+	C.lua_rawgeti(l.State, C.LUA_REGISTRYINDEX, c.ref)
+	C.lua_pushvalue(l.State, C.LUA_GLOBALSINDEX)
+	C.lua_setfenv(l.State, -2)
+	if err := l.call(curTop, envs); err != nil {
+		return nil, err
+	}
+	top := C.lua_gettop(l.State)
+	returns := make([]string, 0, int(top-curTop))
+	for i := curTop + 1; i <= top; i++ {
+		returns = append(returns, l.inspectIndex(i, depth))
+	}
+	return returns, nil
+}
+
+// Dump returns the bytecode of the chunk, which can be loaded by LoadBytecode
+func (c *Chunk) Dump() ([]byte, error) {
+	l := c.lua
+	defer C.lua_settop(l.State, 0)
+	C.lua_rawgeti(l.State, C.LUA_REGISTRYINDEX, c.ref)
+	var data *C.char
+	var size C.size_t
+	ret := C.dump_function(l.State, &data, &size)
+	defer C.free(unsafe.Pointer(data))
+	if ret != 0 {
+		return nil, fmt.Errorf("dump error: %s", c.name)
+	}
+	return C.GoBytes(unsafe.Pointer(data), C.int(size)), nil
+}
+
+// Close releases the chunk
+func (c *Chunk) Close() {
+	if c.ref == C.LUA_NOREF {
+		return
+	}
+	C.luaL_unref(c.lua.State, C.LUA_REGISTRYINDEX, c.ref)
+	c.ref = C.LUA_NOREF
+}
+
+// SetEvalCache makes Peval keep at most size compiled chunks, reusing them for the same code.
+// least recently used chunks are released first. size 0 disables the cache.
+func (l *Lua) SetEvalCache(size int) {
+	if l.evalCache != nil {
+		l.evalCache.resize(0)
+		l.evalCache = nil
+	}
+	if size > 0 {
+		l.evalCache = &chunkCache{
+			size:     size,
+			chunks:   list.New(),
+			elements: make(map[string]*list.Element),
+		}
+	}
+}
+
+type chunkCache struct {
+	size     int
+	chunks   *list.List // most recently used at front
+	elements map[string]*list.Element
+}
+
+func (c *chunkCache) get(l *Lua, code string) (*Chunk, error) {
+	// bytecode may be cached before RefuseBinaryChunks
+	if l.refuseBinary && isBinaryChunk([]byte(code)) {
+		return nil, fmt.Errorf("LOAD ERROR: binary chunk refused")
+	}
+	if elem, ok := c.elements[code]; ok {
+		c.chunks.MoveToFront(elem)
+		return elem.Value.(*Chunk), nil
+	}
+	chunk, err := l.Compile(code)
+	if err != nil {
+		return nil, err
+	}
+	c.elements[code] = c.chunks.PushFront(chunk)
+	c.resize(c.size)
+	return chunk, nil
+}
+
+func (c *chunkCache) resize(size int) {
+	for c.chunks.Len() > size {
+		chunk := c.chunks.Remove(c.chunks.Back()).(*Chunk)
+		delete(c.elements, chunk.name)
+		chunk.Close()
+	}
+}
@@ -0,0 +1,259 @@
+package lua
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadString(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	chunk, err := l.LoadString("double.lua", `return N * 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chunk.Close()
+	if chunk.Name() != "double.lua" {
+		t.Fatalf("bad name")
+	}
+	for i := 0; i < 3; i++ {
+		ret, err := chunk.Run("N", i)
+		if err != nil || ret[0].(float64) != float64(i*2) {
+			t.Fatalf("bad return %v %v", ret, err)
+		}
+	}
+
+	// env is not kept between runs
+	l.Set("N", 21)
+	ret, err := chunk.Run()
+	if err != nil || ret[0].(float64) != 42 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+
+	// error location
+	chunk, err = l.LoadString("broken.lua", "local a = 1\nerror('broken')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chunk.Close()
+	_, err = chunk.Run()
+	if err == nil || !strings.Contains(err.Error(), "broken.lua:2: broken") {
+		t.Fatalf("bad error %v", err)
+	}
+
+	// load error
+	_, err = l.LoadString("syntax.lua", "\nfoo bar")
+	if err == nil || !strings.Contains(err.Error(), "syntax.lua:2:") {
+		t.Fatalf("bad load error %v", err)
+	}
+}
+
+func TestDoFile(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ret, err := l.DoFile("testdata/error.lua", "N", 1)
+	if err != nil || len(ret) != 1 || ret[0].(float64) != 1 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	_, err = l.DoFile("testdata/error.lua", "N", 5)
+	if err == nil || !strings.Contains(err.Error(), "testdata/error.lua:3: too large") {
+		t.Fatalf("bad error %v", err)
+	}
+
+	// reader
+	ret, err = l.DoReader("reader.lua", strings.NewReader("return 1,\n2"))
+	if err != nil || len(ret) != 2 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	_, err = l.DoReader("reader.lua", strings.NewReader("\n\nerror('reader error')"))
+	if err == nil || !strings.Contains(err.Error(), "reader.lua:3: reader error") {
+		t.Fatalf("bad error %v", err)
+	}
+
+	// not exists
+	_, err = l.DoFile("testdata/none.lua")
+	if err == nil {
+		t.Fatalf("allowing missing file")
+	}
+}
+
+func TestCompile(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	chunk, err := l.Compile(`n = (n or 0) + 1 return n`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chunk.Close()
+	for i := 1; i <= 3; i++ {
+		ret, err := chunk.Run()
+		if err != nil || ret[0].(float64) != float64(i) {
+			t.Fatalf("bad return %v %v", ret, err)
+		}
+	}
+
+	// bad code
+	_, err = l.Compile(`foobar 1, 2, 3`)
+	if err == nil || !strings.Contains(err.Error(), "LOAD ERROR") {
+		t.Fatalf("allowing bad code or error %v", err)
+	}
+}
+
+func TestEvalCache(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.SetEvalCache(2)
+	for i := 0; i < 3; i++ {
+		ret, err := l.Peval(`return V`, "V", i)
+		if err != nil || ret[0].(float64) != float64(i) {
+			t.Fatalf("bad return %v %v", ret, err)
+		}
+		ret, err = l.Peval(`return V`)
+		if err != nil || ret[0] != nil {
+			t.Fatalf("env leaked %v %v", ret, err)
+		}
+	}
+	l.Peval(`return 1`)
+	l.Peval(`return 2`)
+	if l.evalCache.chunks.Len() != 2 || len(l.evalCache.elements) != 2 {
+		t.Fatalf("cache not bounded")
+	}
+	if _, ok := l.evalCache.elements[`return V`]; ok {
+		t.Fatalf("least recently used chunk not evicted")
+	}
+
+	// errors
+	_, err = l.Peval(`foobar 1, 2, 3`)
+	if err == nil || !strings.Contains(err.Error(), "LOAD ERROR") {
+		t.Fatalf("allowing bad code or error %v", err)
+	}
+	_, err = l.Peval(`error('cached error')`)
+	if err == nil || !strings.Contains(err.Error(), "cached error") {
+		t.Fatalf("allowing error or error %v", err)
+	}
+
+	// disable
+	l.SetEvalCache(0)
+	if l.evalCache != nil {
+		t.Fatalf("cache not disabled")
+	}
+	ret, err := l.Peval(`return 42`)
+	if err != nil || ret[0].(float64) != 42 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+}
+
+func TestBytecode(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	chunk, err := l.LoadString("add.lua", "local a, b = ...\nreturn A + B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chunk.Close()
+	code, err := chunk.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l2, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+	loaded, err := l2.LoadBytecode("add.luac", code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+	ret, err := loaded.Run("A", 40, "B", 2)
+	if err != nil || ret[0].(float64) != 42 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	// cached before refusing
+	l2.SetEvalCache(4)
+	_, err = l2.Peval(string(code), "A", 1, "B", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// not binary
+	_, err = l2.LoadBytecode("add.lua", []byte("return 1"))
+	if err == nil || !strings.Contains(err.Error(), "not a binary chunk") {
+		t.Fatalf("allowing source code or error %v", err)
+	}
+
+	// refuse
+	l2.RefuseBinaryChunks(true)
+	_, err = l2.LoadBytecode("add.luac", code)
+	if err == nil || !strings.Contains(err.Error(), "binary chunk refused") {
+		t.Fatalf("allowing binary chunk or error %v", err)
+	}
+	_, err = l2.Peval(string(code), "A", 1, "B", 1)
+	if err == nil || !strings.Contains(err.Error(), "binary chunk refused") {
+		t.Fatalf("allowing binary chunk or error %v", err)
+	}
+	if _, err = l2.Peval(`return 1`); err != nil {
+		t.Fatal(err)
+	}
+
+	// lua loaders
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "add.luac")
+	if err := os.WriteFile(binary, code, 0644); err != nil {
+		t.Fatal(err)
+	}
+	text := filepath.Join(dir, "script.lua")
+	if err := os.WriteFile(text, []byte("#!/usr/bin/env lua\nreturn 3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l2.Set("binary", binary, "text", text)
+	for _, code := range []string{
+		`return loadstring(string.dump(function() end))`,
+		`local s = string.dump(function() end)
+		return load(function() local p = s s = nil return p end)`,
+		`return loadfile(binary)`,
+	} {
+		ret, err := l2.Peval(code)
+		if err != nil || ret[0] != nil || !strings.Contains(ret[1].(string), "binary chunk refused") {
+			t.Fatalf("allowing binary chunk %s: %v %v", code, ret, err)
+		}
+	}
+	if _, err := l2.Peval(`dofile(binary)`); err == nil || !strings.Contains(err.Error(), "binary chunk refused") {
+		t.Fatalf("allowing binary chunk or error %v", err)
+	}
+	ret, err = l2.Peval(`
+	local parts = {'return ', '1'}
+	local i = 0
+	return loadstring('return 1')(), load(function() i = i + 1 return parts[i] end)(), loadfile(text)(), dofile(text)`)
+	if err != nil || ret[0] != 1.0 || ret[1] != 1.0 || ret[2] != 3.0 || ret[3] != 3.0 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	l2.RefuseBinaryChunks(false)
+	ret, err = l2.Peval(`return loadstring(string.dump(function() return 42 end))()`)
+	if err != nil || ret[0] != 42.0 {
+		t.Fatalf("loaders not restored %v %v", ret, err)
+	}
+}
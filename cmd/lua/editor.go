@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"unicode"
+)
+
+// errInterrupted is returned by readLine when the line is discarded by ctrl-c
+var errInterrupted = errors.New("interrupted")
+
+// lineReader reads a line of input after showing prompt
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+// scanReader reads lines without editing, for input not from a terminal
+type scanReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func newScanReader(in io.Reader, out io.Writer) *scanReader {
+	return &scanReader{
+		scanner: bufio.NewScanner(in),
+		out:     out,
+	}
+}
+
+func (r *scanReader) readLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+// editor reads lines from a terminal, with cursor movement and history of entered lines.
+// keys:
+//
+//	left, right, ctrl-b, ctrl-f  move cursor
+//	home, end, ctrl-a, ctrl-e    move to start or end
+//	up, down, ctrl-p, ctrl-n     previous or next line in history
+//	backspace, delete            delete a character
+//	ctrl-u, ctrl-k               delete before or after cursor
+//	ctrl-c                       discard the line
+//	ctrl-d                       exit on empty line
+type editor struct {
+	in      *bufio.Reader
+	out     io.Writer
+	raw     func() (restore func(), err error) // sets the terminal to raw mode while reading
+	history []string
+}
+
+func newEditor(in io.Reader, out io.Writer, raw func() (func(), error)) *editor {
+	return &editor{
+		in:  bufio.NewReader(in),
+		out: out,
+		raw: raw,
+	}
+}
+
+func ctrl(r rune) rune {
+	return r & 0x1f
+}
+
+// keyDelete is the delete key, which is not a control character
+const keyDelete rune = -1
+
+func (e *editor) readLine(prompt string) (string, error) {
+	if e.raw != nil {
+		restore, err := e.raw()
+		if err != nil {
+			return "", err
+		}
+		defer restore()
+	}
+	fmt.Fprint(e.out, prompt)
+	var line, saved []rune
+	pos := 0
+	index := len(e.history) // history entry shown, len(e.history) for the line being edited
+	show := func(entry []rune) {
+		line = append([]rune(nil), entry...)
+		pos = len(line)
+	}
+	for {
+		r, err := e.key()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return e.done(line), nil
+			}
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			return e.done(line), nil
+		case ctrl('c'):
+			fmt.Fprint(e.out, "^C\n")
+			return "", errInterrupted
+		case ctrl('d'), keyDelete:
+			if len(line) == 0 && r == ctrl('d') {
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case ctrl('a'):
+			pos = 0
+		case ctrl('e'):
+			pos = len(line)
+		case ctrl('b'):
+			if pos > 0 {
+				pos--
+			}
+		case ctrl('f'):
+			if pos < len(line) {
+				pos++
+			}
+		case ctrl('u'):
+			line = append(line[:0], line[pos:]...)
+			pos = 0
+		case ctrl('k'):
+			line = line[:pos]
+		case 127, ctrl('h'):
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case ctrl('p'):
+			if index > 0 {
+				if index == len(e.history) {
+					saved = line
+				}
+				index--
+				show([]rune(e.history[index]))
+			}
+		case ctrl('n'):
+			if index < len(e.history) {
+				index++
+				if index == len(e.history) {
+					show(saved)
+				} else {
+					show([]rune(e.history[index]))
+				}
+			}
+		default:
+			if unicode.IsPrint(r) {
+				line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+				pos++
+			}
+		}
+		e.refresh(prompt, line, pos)
+	}
+}
+
+// key reads a key. escape sequences of arrow and editing keys are returned as
+// the control keys doing the same, unknown sequences as 0.
+func (e *editor) key() (rune, error) {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != 27 {
+		return r, err
+	}
+	r, _, err = e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return 0, err
+	}
+	r, _, err = e.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	switch r {
+	case 'A':
+		return ctrl('p'), nil
+	case 'B':
+		return ctrl('n'), nil
+	case 'C':
+		return ctrl('f'), nil
+	case 'D':
+		return ctrl('b'), nil
+	case 'H':
+		return ctrl('a'), nil
+	case 'F':
+		return ctrl('e'), nil
+	}
+	// numbered keys like 3~ for delete
+	n := 0
+	for r >= '0' && r <= '9' {
+		n = n*10 + int(r-'0')
+		if r, _, err = e.in.ReadRune(); err != nil {
+			return 0, err
+		}
+	}
+	if r != '~' {
+		return 0, nil
+	}
+	switch n {
+	case 1, 7:
+		return ctrl('a'), nil
+	case 4, 8:
+		return ctrl('e'), nil
+	case 3:
+		return keyDelete, nil
+	}
+	return 0, nil
+}
+
+// done ends the line and records it in history
+func (e *editor) done(line []rune) string {
+	fmt.Fprint(e.out, "\n")
+	s := string(line)
+	if s != "" && (len(e.history) == 0 || e.history[len(e.history)-1] != s) {
+		e.history = append(e.history, s)
+	}
+	return s
+}
+
+// refresh redraws the line and puts the cursor at pos
+func (e *editor) refresh(prompt string, line []rune, pos int) {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(line))
+	if n := len(line) - pos; n > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", n)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestEditor(t *testing.T) {
+	out := new(bytes.Buffer)
+	e := newEditor(strings.NewReader(
+		"abc\x1b[D\x1b[DX\r"+ // insert in the middle
+			"hello\x01\x1b[3~J\x05!\n"+ // home, delete, end
+			"\x1b[A\x1b[A\x7f\r"+ // recall and backspace
+			"foo\x03"+ // discard
+			"bar\x15baz\x1b[15~\x1b[A\x1b[B\r"+ // kill line, unknown key, browse back
+			"\x04",
+	), out, nil)
+	for _, expected := range []string{"aXbc", "Jello!", "aXb", "", "baz"} {
+		line, err := e.readLine("> ")
+		if expected == "" {
+			if err != errInterrupted {
+				t.Fatalf("not interrupted %q %v", line, err)
+			}
+			continue
+		}
+		if err != nil || line != expected {
+			t.Fatalf("expected %q, got %q %v", expected, line, err)
+		}
+	}
+	if _, err := e.readLine("> "); err != io.EOF {
+		t.Fatalf("expected eof, got %v", err)
+	}
+	if len(e.history) != 4 || e.history[2] != "aXb" {
+		t.Fatalf("bad history %q", e.history)
+	}
+	if !strings.Contains(out.String(), "^C\n") || !strings.Contains(out.String(), "\r> aXbc\x1b[K") {
+		t.Fatalf("bad output %q", out.String())
+	}
+}
@@ -0,0 +1,95 @@
+// Command lua runs lua scripts on this binding, or starts an interactive shell if no script is given.
+//
+//	lua [flags] [script.lua [args]]
+//
+// Script arguments are in the global table arg, with the script path at arg[0].
+// Exit status is 0 on success, 1 on runtime errors, 2 on usage errors,
+// 3 on load errors and 4 when a limit is exceeded.
+//
+// The -mem and -timeout limits are approximate, not hard limits. They are checked every 10000 vm
+// instructions, so a single large allocation, or time spent in a C or Go function, can exceed them
+// before the script is stopped. Limits do not apply to the shell.
+//
+// In the shell, lines are evaluated as expressions first, then as statements. Input is
+// continued on the next line while the chunk is incomplete. Commands:
+//
+//	:load file.lua  run a file
+//	:history        list entered code
+//	!!              run the last entry again
+//	!n              run entry n again
+//	:help           show help
+//	:quit           exit
+//
+// Input from a terminal can be edited with arrow keys and emacs-like control keys, up and down
+// recall earlier lines, ctrl-c discards the entry and ctrl-d on an empty line exits.
+// Raw terminal mode is only supported on linux, input is read line by line on others.
+//
+// Modules gostrings and gotime are registered for demonstration, try
+// require('gostrings').upper('foo').
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// exit statuses
+const (
+	exitOK = iota
+	exitRuntimeError
+	exitUsage
+	exitLoadError
+	exitLimit
+)
+
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+type options struct {
+	libs    string
+	memory  int
+	timeout time.Duration
+	paths   listFlag
+	globals listFlag
+	envs    listFlag
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.libs, "libs", "all", "comma separated standard libraries to open: base,package,table,io,os,string,math,debug,bit,jit,ffi, or all")
+	flag.IntVar(&opts.memory, "mem", 0, "approximate memory limit in megabytes, checked every 10000 instructions, 0 for no limit")
+	flag.DurationVar(&opts.timeout, "timeout", 0, "approximate time limit, checked every 10000 instructions, 0 for no limit")
+	flag.Var(&opts.paths, "path", "directory to search modules in, can be repeated")
+	flag.Var(&opts.globals, "g", "name=value to set a global string, can be repeated")
+	flag.Var(&opts.envs, "env", "name of an environment variable to set as a global, can be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [script.lua [args]]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	l, err := newLua(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+	var status int
+	if flag.NArg() == 0 {
+		status = shell(l)
+	} else {
+		status = run(l, opts, flag.Arg(0), flag.Args()[1:])
+	}
+	l.Close()
+	os.Exit(status)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/reusee/lua"
+)
+
+const help = `:load file.lua  run a file
+:history        list entered code
+!!              run the last entry again
+!n              run entry n again
+:help           show help
+:quit           exit`
+
+// depth of tables shown
+const inspectDepth = 4
+
+// shell runs the interactive shell and returns the exit status
+func shell(l *lua.Lua) int {
+	registerDemoModules(l)
+	fmt.Println("lua shell, :help for help")
+	var lines lineReader = newScanReader(os.Stdin, os.Stdout)
+	fd := int(os.Stdin.Fd())
+	if restore, err := makeRaw(fd); err == nil {
+		// a terminal
+		restore()
+		lines = newEditor(os.Stdin, os.Stdout, func() (func(), error) {
+			return makeRaw(fd)
+		})
+	}
+	repl(l, lines, os.Stdout)
+	return exitOK
+}
+
+func registerDemoModules(l *lua.Lua) {
+	l.RegisterModule("gostrings", func(*lua.Lua) map[string]interface{} {
+		return map[string]interface{}{
+			"upper":  strings.ToUpper,
+			"lower":  strings.ToLower,
+			"repeat": strings.Repeat,
+			"split":  strings.Split,
+		}
+	})
+	l.RegisterModule("gotime", func(*lua.Lua) map[string]interface{} {
+		return map[string]interface{}{
+			"now": func() float64 {
+				return float64(time.Now().UnixNano()) / 1e9
+			},
+			"sleep": func(seconds float64) {
+				time.Sleep(time.Duration(seconds * float64(time.Second)))
+			},
+		}
+	})
+}
+
+// repl evaluates code read from lines, which may be multi-line entries.
+// entries are listed and run again by the history commands.
+func repl(l *lua.Lua, lines lineReader, out io.Writer) {
+	var buf string
+	var history []string
+	for {
+		prompt := "> "
+		if buf != "" {
+			prompt = ">> "
+		}
+		line, err := lines.readLine(prompt)
+		if err == errInterrupted {
+			buf = ""
+			continue
+		} else if err != nil {
+			fmt.Fprintln(out)
+			return
+		}
+		if buf == "" && strings.HasPrefix(line, ":") {
+			if !command(l, line, history, out) {
+				return
+			}
+			continue
+		}
+		if buf == "" && strings.HasPrefix(line, "!") {
+			entry, err := recall(history, line)
+			if err != nil {
+				fmt.Fprintln(out, err)
+				continue
+			}
+			fmt.Fprintln(out, entry)
+			line = entry
+		}
+		if buf != "" {
+			buf += "\n"
+		}
+		buf += line
+		result, incomplete, err := eval(l, buf)
+		if incomplete {
+			continue
+		}
+		history = append(history, buf)
+		buf = ""
+		if err != nil {
+			fmt.Fprintln(out, err)
+		} else if result != "" {
+			fmt.Fprintln(out, result)
+		}
+	}
+}
+
+// recall returns the history entry referred by !! or !n
+func recall(history []string, line string) (string, error) {
+	if line == "!!" {
+		if len(history) == 0 {
+			return "", fmt.Errorf("no history")
+		}
+		return history[len(history)-1], nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 || n > len(history) {
+		return "", fmt.Errorf("no history entry %s", line[1:])
+	}
+	return history[n-1], nil
+}
+
+func command(l *lua.Lua, line string, history []string, out io.Writer) (next bool) {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case ":quit", ":q":
+		return false
+	case ":help":
+		fmt.Fprintln(out, help)
+	case ":history":
+		for i, entry := range history {
+			fmt.Fprintf(out, "%4d  %s\n", i+1, strings.Replace(entry, "\n", "\n      ", -1))
+		}
+	case ":load":
+		if len(fields) != 2 {
+			fmt.Fprintln(out, "usage: :load file.lua")
+			break
+		}
+		ret, err := l.DoFile(fields[1])
+		if err != nil {
+			fmt.Fprintln(out, err)
+		} else if len(ret) > 0 {
+			fmt.Fprintln(out, ret...)
+		}
+	default:
+		fmt.Fprintf(out, "unknown command %s\n", fields[0])
+	}
+	return true
+}
+
+// eval runs code as an expression, or as statements if it's not an expression
+func eval(l *lua.Lua, code string) (result string, incomplete bool, err error) {
+	// newline to end trailing comments
+	chunk, err := l.LoadString("stdin", "return "+code+"\n")
+	if err != nil {
+		chunk, err = l.LoadString("stdin", code)
+		if err != nil {
+			if strings.HasSuffix(err.Error(), "'<eof>'") {
+				return "", true, nil
+			}
+			return "", false, err
+		}
+	}
+	defer chunk.Close()
+	values, err := chunk.RunInspect(inspectDepth)
+	if err != nil {
+		return "", false, err
+	}
+	return strings.Join(values, "\t"), false, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/reusee/lua"
+)
+
+func TestREPL(t *testing.T) {
+	l, err := lua.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	registerDemoModules(l)
+
+	out := new(bytes.Buffer)
+	repl(l, newScanReader(strings.NewReader(`1 + 1
+t = {1, 2, name = 'foo'}
+t
+function f(n)
+  return n * 2
+end
+f(21)
+1, 'a', {print}
+require('gostrings').upper('foo')
+error('oops')
+:load testdata/none.lua
+:foo
+:quit
+`), out), out)
+	for _, s := range []string{
+		"> 2\n",
+		`{1, 2, name = "foo"}`,
+		">> >> > 42\n",
+		"1\t\"a\"\t{<function [C]",
+		`"FOO"`,
+		"oops",
+		"none.lua",
+		"unknown command :foo",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("%q not in output:\n%s", s, out.String())
+		}
+	}
+}
+
+func TestREPLHistory(t *testing.T) {
+	l, err := lua.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	out := new(bytes.Buffer)
+	repl(l, newScanReader(strings.NewReader(`n = 1
+n = n + 1
+!!
+function f()
+  return n * 10
+end
+!4
+f()
+!9
+:history
+`), out), out)
+	for _, s := range []string{
+		"> n = n + 1\n",
+		"> function f()\n  return n * 10\nend\n",
+		"> 30\n",
+		"no history entry 9",
+		"   2  n = n + 1\n",
+		"   4  function f()\n        return n * 10\n      end\n",
+		"   6  f()\n",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("%q not in output:\n%s", s, out.String())
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/reusee/lua"
+)
+
+// newLua creates a vm with libraries, module paths and globals set by options
+func newLua(opts options) (*lua.Lua, error) {
+	var l *lua.Lua
+	var err error
+	if opts.libs == "all" {
+		l, err = lua.New()
+	} else {
+		var libs []string
+		for _, lib := range strings.Split(opts.libs, ",") {
+			if lib = strings.TrimSpace(lib); lib != "" {
+				libs = append(libs, lib)
+			}
+		}
+		l, err = lua.NewWithLibs(libs...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range opts.paths {
+		if err := l.AddModuleFS(os.DirFS(path)); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	for _, global := range opts.globals {
+		name, value, ok := strings.Cut(global, "=")
+		if !ok {
+			l.Close()
+			return nil, fmt.Errorf("bad global %q, expecting name=value", global)
+		}
+		if err := l.Pset(name, value); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	for _, name := range opts.envs {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := l.Pset(name, value); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// run runs the script and returns the exit status
+func run(l *lua.Lua, opts options, script string, args []string) int {
+	if err := l.Pset("arg", args, "arg[0]", script); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	// limits are checked every 10000 instructions, so they are approximate
+	var limitErr string
+	if opts.memory > 0 || opts.timeout > 0 {
+		deadline := time.Now().Add(opts.timeout)
+		l.SetHook(lua.HookCount, 10000, func(ev lua.HookEvent) {
+			switch {
+			case opts.memory > 0 && l.MemoryUsage() > opts.memory*1024*1024:
+				limitErr = "memory limit exceeded"
+			case opts.timeout > 0 && time.Now().After(deadline):
+				limitErr = "time limit exceeded"
+			default:
+				return
+			}
+			ev.Abort(limitErr)
+		})
+	}
+
+	_, err := l.DoFile(script)
+	if err == nil {
+		return exitOK
+	}
+	fmt.Fprintln(os.Stderr, err)
+	// errors are distinguished by their prefixes
+	switch msg := err.Error(); {
+	case limitErr != "":
+		return exitLimit
+	case strings.HasPrefix(msg, "LOAD ERROR:"):
+		return exitLoadError
+	case strings.HasPrefix(msg, "CALL ERROR:"):
+		return exitRuntimeError
+	case os.IsNotExist(err):
+		return exitUsage
+	}
+	return exitRuntimeError
+}
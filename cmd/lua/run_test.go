@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, code string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(code), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("lib/greet.lua", `return function(name) return 'hello, ' .. name end`)
+	os.Setenv("LUA_RUN_TEST", "bar")
+
+	for _, c := range []struct {
+		name   string
+		code   string
+		opts   options
+		args   []string
+		status int
+	}{
+		{
+			"ok.lua",
+			`assert(require('greet')(NAME) == 'hello, foo')
+			assert(conf.mode == 'test')
+			assert(LUA_RUN_TEST == 'bar')
+			assert(arg[0]:match('ok.lua$') and arg[1] == 'a' and arg[2] == 'b')`,
+			options{
+				libs:    "all",
+				paths:   listFlag{filepath.Join(dir, "lib")},
+				globals: listFlag{"NAME=foo", "conf.mode=test"},
+				envs:    listFlag{"LUA_RUN_TEST"},
+			},
+			[]string{"a", "b"},
+			exitOK,
+		},
+		{"error.lua", `error('oops')`, options{libs: "all"}, nil, exitRuntimeError},
+		{"syntax.lua", `x = = 1`, options{libs: "all"}, nil, exitLoadError},
+		{"timeout.lua", `while true do end`, options{libs: "all", timeout: time.Millisecond * 100}, nil, exitLimit},
+		{"memory.lua", `
+			local t = {}
+			for i = 1, 1e8 do t[i] = {} end
+		`, options{libs: "all", memory: 16}, nil, exitLimit},
+		{"libs.lua", `assert(string and not io and not os)`, options{libs: "base,string"}, nil, exitOK},
+		{"nodebug.lua", `error('oops')`, options{libs: "base"}, nil, exitRuntimeError},
+	} {
+		path := write(c.name, c.code)
+		l, err := newLua(c.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status := run(l, c.opts, path, c.args); status != c.status {
+			t.Fatalf("%s: bad status %d", c.name, status)
+		}
+		l.Close()
+	}
+
+	if _, err := newLua(options{libs: "foo"}); err == nil {
+		t.Fatalf("should fail")
+	}
+	if _, err := newLua(options{libs: "all", globals: listFlag{"foo"}}); err == nil {
+		t.Fatalf("should fail")
+	}
+	l, err := newLua(options{libs: "all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if status := run(l, options{}, filepath.Join(dir, "none.lua"), nil); status != exitUsage {
+		t.Fatalf("bad status %d", status)
+	}
+}
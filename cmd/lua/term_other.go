@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// makeRaw is only supported on linux, input is read without editing on others
+func makeRaw(fd int) (restore func(), err error) {
+	return nil, fmt.Errorf("raw mode not supported")
+}
@@ -0,0 +1,54 @@
+package lua
+
+/*
+#include <lua.h>
+*/
+import "C"
+import (
+	"fmt"
+	"math"
+)
+
+// SetCoercion makes arguments of go functions converted like lua arithmetic and concatenation:
+// strings like "42" are accepted for number parameters, and numbers for string parameters.
+// it's disabled by default.
+func (l *Lua) SetCoercion(coerce bool) {
+	l.coerce = coerce
+}
+
+// Coerce wraps a go function to convert its arguments like SetCoercion
+func Coerce(fn interface{}) *Function {
+	f := asFunction(fn)
+	f.coerce = true
+	return f
+}
+
+// toNumber returns the number at i. strings convertible to numbers are accepted if coerce is true.
+func (l *Lua) toNumber(i C.int, luaType C.int, coerce bool) (float64, bool) {
+	if luaType != C.LUA_TNUMBER && !(coerce && luaType == C.LUA_TSTRING && C.lua_isnumber(l.State, i) != 0) {
+		return 0, false
+	}
+	return float64(C.lua_tonumber(l.State, i)), true
+}
+
+// numberToString converts the number at i like tostring, without changing the value on the stack
+func (l *Lua) numberToString(i C.int) string {
+	C.lua_pushvalue(l.State, i)
+	defer C.lua_settop(l.State, -2)
+	return l.toString(-1)
+}
+
+// checkInteger rejects nan, infinities and numbers with fractional parts for integer parameters
+func checkInteger(n float64) error {
+	switch {
+	case math.IsNaN(n):
+		return fmt.Errorf("not an integer: nan")
+	case math.IsInf(n, 1):
+		return fmt.Errorf("not an integer: inf")
+	case math.IsInf(n, -1):
+		return fmt.Errorf("not an integer: -inf")
+	case n != math.Trunc(n):
+		return fmt.Errorf("not an integer: %s has fractional part", formatNumber(n))
+	}
+	return nil
+}
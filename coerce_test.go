@@ -0,0 +1,95 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCoercion(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	add := func(a int, b float64) float64 {
+		return float64(a) + b
+	}
+	concat := func(a, b string) string {
+		return a + b
+	}
+	l.Set("add", add, "concat", concat)
+	l.Set("coerced_add", Coerce(add), "coerced_concat", Coerce(concat))
+
+	// not coerced by default
+	for _, code := range []string{`add('1', 2)`, `add(1, '2')`, `concat(1, 'a')`} {
+		if _, err := l.Peval(code); err == nil {
+			t.Fatalf("%s: should fail", code)
+		}
+	}
+	// per function
+	ret, err := l.Peval(`return coerced_add('1', ' 2.5 '), coerced_add(1, '0x10'), coerced_concat(1, 2.5)`)
+	if err != nil || ret[0] != 3.5 || ret[1] != 17.0 || ret[2] != "12.5" {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	if _, err := l.Peval(`coerced_add('foo', 1)`); err == nil || !strings.Contains(err.Error(), "not an integer") {
+		t.Fatalf("should fail %v", err)
+	}
+	if _, err := l.Peval(`coerced_concat({}, 1)`); err == nil || !strings.Contains(err.Error(), "not a string") {
+		t.Fatalf("should fail %v", err)
+	}
+
+	// integer checks, always on
+	for code, msg := range map[string]string{
+		`add(3.5, 1)`:           "3.5 has fractional part",
+		`coerced_add('3.5', 1)`: "3.5 has fractional part",
+		`add(2^70, 1)`:          "integer out of range",
+		`add(1/0, 1)`:           "not an integer: inf",
+		`add(-1/0, 1)`:          "not an integer: -inf",
+		`add(0/0, 1)`:           "not an integer: nan",
+	} {
+		if _, err := l.Peval(code); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("%s: should fail %v", code, err)
+		}
+	}
+	l.Set("byte", func(b uint8) uint8 {
+		return b
+	})
+	for _, code := range []string{`byte(256)`, `byte(-1)`} {
+		if _, err := l.Peval(code); err == nil || !strings.Contains(err.Error(), "integer out of range") {
+			t.Fatalf("%s: should fail %v", code, err)
+		}
+	}
+	ret, err = l.Peval(`return byte(255), add(-3, 0.5)`)
+	if err != nil || ret[0] != 255.0 || ret[1] != -2.5 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+
+	// per vm
+	l.SetCoercion(true)
+	ret, err = l.Peval(`return add('1', '2'), concat(1, 'a')`)
+	if err != nil || ret[0] != 3.0 || ret[1] != "1a" {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	var n int
+	l.Set("s", "42")
+	if err := l.GetInto("s", &n); err != nil || n != 42 {
+		t.Fatalf("bad get %v %v", n, err)
+	}
+	l.SetCoercion(false)
+	if err := l.GetInto("s", &n); err == nil {
+		t.Fatalf("should fail")
+	}
+
+	// call context
+	l.Set("ctx", Coerce(func(c *CallContext) int {
+		return c.Return(c.Int(1, 0), c.String(2, ""))
+	}))
+	ret, err = l.Peval(`return ctx('7', 8)`)
+	if err != nil || ret[0] != 7.0 || ret[1] != "8" {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	if _, err := l.Peval(`ctx(7.5)`); err == nil || !strings.Contains(err.Error(), "fractional part") {
+		t.Fatalf("should fail %v", err)
+	}
+}
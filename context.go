@@ -0,0 +1,214 @@
+package lua
+
+/*
+#include <lua.h>
+*/
+import "C"
+import (
+	"fmt"
+	"reflect"
+)
+
+// CallContext gives go functions of type func(*CallContext) int direct access to lua arguments and results.
+// such functions accept any number of arguments, and return the number of pushed results.
+// errors of getters and push helpers are raised as lua errors when the function returns,
+// so they stop the calling script and can be caught by pcall.
+// the context can only be used before the function returns.
+type CallContext struct {
+	lua      *Lua
+	function *_Function
+	coerce   bool
+	err      error
+	pushed   int
+}
+
+// NumArgs returns the number of arguments
+func (c *CallContext) NumArgs() int {
+	return int(C.lua_gettop(c.lua.State))
+}
+
+// Type returns the lua type name of the ith argument, starting from 1. returns "none" if not passed.
+func (c *CallContext) Type(i int) string {
+	if i < 1 || i > c.NumArgs() {
+		return "none"
+	}
+	return C.GoString(C.lua_typename(c.lua.State, C.lua_type(c.lua.State, C.int(i))))
+}
+
+// IsNil reports whether the ith argument is nil or not passed
+func (c *CallContext) IsNil(i int) bool {
+	return i < 1 || i > c.NumArgs() || C.lua_type(c.lua.State, C.int(i)) == C.LUA_TNIL
+}
+
+// Inspect renders the i-th argument like Lua.Inspect. returns nil for missing arguments.
+func (c *CallContext) Inspect(i int, depth int) string {
+	if i < 1 || i > c.NumArgs() {
+		return "nil"
+	}
+	return c.lua.inspectIndex(C.int(i), depth)
+}
+
+// Info returns the caller's info like *CallInfo parameters
+func (c *CallContext) Info() *CallInfo {
+	return c.lua.callInfo(c.function)
+}
+
+func (c *CallContext) argError(i int, expected string) {
+	if c.err == nil {
+		c.err = fmt.Errorf("bad argument #%d to %s: %s expected, got %s", i, c.function.name, expected, c.Type(i))
+	}
+}
+
+// check returns false if the ith argument is nil or not of the lua type
+func (c *CallContext) check(i int, luaType C.int, expected string) bool {
+	if c.IsNil(i) {
+		return false
+	}
+	t := C.lua_type(c.lua.State, C.int(i))
+	if t != luaType && !(c.coerce && c.coercible(i, t, luaType)) {
+		c.argError(i, expected)
+		return false
+	}
+	return true
+}
+
+// coercible reports whether the ith argument of type t can be converted to luaType in coercion mode
+func (c *CallContext) coercible(i int, t C.int, luaType C.int) bool {
+	switch luaType {
+	case C.LUA_TNUMBER:
+		return t == C.LUA_TSTRING && C.lua_isnumber(c.lua.State, C.int(i)) != 0
+	case C.LUA_TSTRING:
+		return t == C.LUA_TNUMBER
+	}
+	return false
+}
+
+// convert converts the ith argument to the type dst points to. returns false on errors.
+func (c *CallContext) convert(i int, dst interface{}) bool {
+	ptr := reflect.ValueOf(dst)
+	value, err := c.lua.toGoValueWith(C.int(i), ptr.Elem().Type(), c.coerce)
+	if err != nil {
+		if c.err == nil {
+			c.err = fmt.Errorf("bad argument #%d to %s: %v", i, c.function.name, err)
+		}
+		return false
+	}
+	ptr.Elem().Set(*value)
+	return true
+}
+
+// Int returns the ith argument as an int, or def if it's nil or not passed.
+// numbers with fractional parts or out of range are errors.
+func (c *CallContext) Int(i int, def int) int {
+	var n int
+	if !c.check(i, C.LUA_TNUMBER, "number") || !c.convert(i, &n) {
+		return def
+	}
+	return n
+}
+
+// Float returns the ith argument as a float64, or def if it's nil or not passed
+func (c *CallContext) Float(i int, def float64) float64 {
+	var n float64
+	if !c.check(i, C.LUA_TNUMBER, "number") || !c.convert(i, &n) {
+		return def
+	}
+	return n
+}
+
+// String returns the ith argument as a string, or def if it's nil or not passed
+func (c *CallContext) String(i int, def string) string {
+	if !c.check(i, C.LUA_TSTRING, "string") {
+		return def
+	}
+	if C.lua_type(c.lua.State, C.int(i)) == C.LUA_TNUMBER {
+		return c.lua.numberToString(C.int(i))
+	}
+	return c.lua.toString(C.int(i))
+}
+
+// Bool returns the ith argument as a bool, or def if it's nil or not passed
+func (c *CallContext) Bool(i int, def bool) bool {
+	if !c.check(i, C.LUA_TBOOLEAN, "boolean") {
+		return def
+	}
+	return C.lua_toboolean(c.lua.State, C.int(i)) != 0
+}
+
+// Value returns the ith argument converted like Get. returns nil if not passed.
+func (c *CallContext) Value(i int) interface{} {
+	if c.IsNil(i) {
+		return nil
+	}
+	value, err := c.lua.toGoValue(C.int(i), interfaceType)
+	if err != nil {
+		if c.err == nil {
+			c.err = fmt.Errorf("bad argument #%d to %s: %v", i, c.function.name, err)
+		}
+		return nil
+	}
+	if value == nil {
+		return nil
+	}
+	return value.Interface()
+}
+
+// Into converts the ith argument to the type dst points to, like arguments of go functions.
+// dst is not changed if the argument is nil or not passed.
+func (c *CallContext) Into(i int, dst interface{}) {
+	ptr := reflect.ValueOf(dst)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		if c.err == nil {
+			c.err = fmt.Errorf("dst must be a non-nil pointer, not %v", dst)
+		}
+		return
+	}
+	if c.IsNil(i) {
+		return
+	}
+	value, err := c.lua.toGoValueWith(C.int(i), ptr.Elem().Type(), c.coerce)
+	if err != nil {
+		if c.err == nil {
+			c.err = fmt.Errorf("bad argument #%d to %s: %v", i, c.function.name, err)
+		}
+		return
+	}
+	if value == nil {
+		ptr.Elem().Set(reflect.Zero(ptr.Elem().Type()))
+		return
+	}
+	ptr.Elem().Set(*value)
+}
+
+// Push pushes a result
+func (c *CallContext) Push(v interface{}) {
+	if err := c.lua.pushGoValue(v, ""); err != nil {
+		if c.err == nil {
+			c.err = err
+		}
+		// keep the count
+		C.lua_pushnil(c.lua.State)
+	}
+	c.pushed++
+}
+
+// Return pushes results and returns the number of results pushed by the context
+func (c *CallContext) Return(values ...interface{}) int {
+	for _, v := range values {
+		c.Push(v)
+	}
+	return c.pushed
+}
+
+// Error makes err raised as a lua error when the function returns. returns 0 for convenience.
+func (c *CallContext) Error(err error) int {
+	if c.err == nil {
+		c.err = err
+	}
+	return 0
+}
+
+// Errorf is like Error with a formatted message
+func (c *CallContext) Errorf(format string, args ...interface{}) int {
+	return c.Error(fmt.Errorf(format, args...))
+}
@@ -0,0 +1,80 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCallContext(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.Set("format", func(c *CallContext) int {
+		if c.NumArgs() == 0 {
+			return c.Errorf("no arguments")
+		}
+		name := c.String(1, "")
+		times := c.Int(2, 1)
+		scale := c.Float(3, 1.5)
+		upper := c.Bool(4, false)
+		var tags []string
+		c.Into(5, &tags)
+		ret := strings.Repeat(name, times)
+		if upper {
+			ret = strings.ToUpper(ret)
+		}
+		c.Push(ret)
+		return c.Return(scale, strings.Join(tags, ","), c.Type(2), c.IsNil(3), c.Value(6))
+	})
+
+	ret, err := l.Peval(`return format('foo')`)
+	if err != nil || len(ret) != 6 || ret[0] != "foo" || ret[1] != 1.5 || ret[2] != "" ||
+		ret[3] != "none" || ret[4] != true || ret[5] != nil {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	ret, err = l.Peval(`return format('foo', 2, nil, true, {'a', 'b'}, 42)`)
+	if err != nil || ret[0] != "FOOFOO" || ret[1] != 1.5 || ret[2] != "a,b" ||
+		ret[3] != "number" || ret[4] != true || ret[5] != 42.0 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+
+	// errors
+	if _, err := l.Peval(`format()`); err == nil || !strings.Contains(err.Error(), "no arguments") {
+		t.Fatalf("should fail %v", err)
+	}
+	_, err = l.Peval(`format('foo', 'bar')`)
+	if err == nil || !strings.Contains(err.Error(), "bad argument #2 to format: number expected, got string") {
+		t.Fatalf("should fail %v", err)
+	}
+	if _, err := l.Peval(`format('foo', 1, 1, true, 5)`); err == nil {
+		t.Fatalf("should fail")
+	}
+
+	// raised as lua errors
+	ret, err = l.Peval(`
+	local ok, err = pcall(format)
+	return ok, err`)
+	if err != nil || ret[0] != false || !strings.Contains(ret[1].(string), "no arguments") {
+		t.Fatalf("error not caught %v %v", ret, err)
+	}
+	ret, err = l.Peval(`
+	format()
+	after = true`)
+	if err == nil || l.Has("after") {
+		t.Fatalf("script not stopped %v", err)
+	}
+
+	// caller info
+	l.Set("line", func(c *CallContext) int {
+		return c.Return(c.Info().Line)
+	})
+	ret, err = l.Peval(`
+	local n = line()
+	return n`)
+	if err != nil || ret[0] != 2.0 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+}
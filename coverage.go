@@ -0,0 +1,174 @@
+package lua
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Coverage records executed lines of lua code loaded from files and named chunks.
+// it can be shared by many lua vms.
+type Coverage struct {
+	lock sync.Mutex
+	// by chunk name, then line. lines having code but not executed are zero.
+	files map[string]map[int]int
+	// functions whose lines are recorded
+	functions map[coverageFunction]bool
+}
+
+type coverageFunction struct {
+	source string
+	line   int
+}
+
+// NewCoverage creates an empty coverage
+func NewCoverage() *Coverage {
+	return &Coverage{
+		files:     make(map[string]map[int]int),
+		functions: make(map[coverageFunction]bool),
+	}
+}
+
+// SetCoverage makes the vm record executed lines in c. nil stops recording.
+// it replaces the coverage set before, and runs together with hooks of SetHook and StartProfile.
+func (l *Lua) SetCoverage(c *Coverage) {
+	main := l.mainLua()
+	l.removeHook(main.coverageHook)
+	main.coverageHook = nil
+	if c != nil {
+		main.coverageHook = l.addHook(HookCall|HookLine, 0, c.hook)
+	}
+}
+
+func (c *Coverage) hook(ev HookEvent) {
+	// code strings have no name
+	if !strings.HasPrefix(ev.Source, "@") {
+		return
+	}
+	name := ev.Source[1:]
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	lines, ok := c.files[name]
+	if !ok {
+		lines = make(map[int]int)
+		c.files[name] = lines
+	}
+	switch ev.Event {
+	case HookCall:
+		fn := coverageFunction{ev.Source, ev.LineDefined}
+		if ev.What == "C" || c.functions[fn] {
+			return
+		}
+		c.functions[fn] = true
+		for _, line := range ev.ActiveLines() {
+			if _, ok := lines[line]; !ok {
+				lines[line] = 0
+			}
+		}
+	case HookLine:
+		lines[ev.Line]++
+	}
+}
+
+// Merge adds lines recorded by other to c
+func (c *Coverage) Merge(other *Coverage) {
+	if other == c {
+		return
+	}
+	// copy first, holding both locks deadlocks when two coverages merge each other
+	other.lock.Lock()
+	files := make(map[string]map[int]int, len(other.files))
+	for name, otherLines := range other.files {
+		lines := make(map[int]int, len(otherLines))
+		for line, count := range otherLines {
+			lines[line] = count
+		}
+		files[name] = lines
+	}
+	functions := make([]coverageFunction, 0, len(other.functions))
+	for fn := range other.functions {
+		functions = append(functions, fn)
+	}
+	other.lock.Unlock()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for name, otherLines := range files {
+		lines, ok := c.files[name]
+		if !ok {
+			lines = make(map[int]int)
+			c.files[name] = lines
+		}
+		for line, count := range otherLines {
+			lines[line] += count
+		}
+	}
+	for _, fn := range functions {
+		c.functions[fn] = true
+	}
+}
+
+// Lines returns execution counts of lines in the named chunk
+func (c *Coverage) Lines(name string) map[int]int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ret := make(map[int]int)
+	for line, count := range c.files[name] {
+		ret[line] = count
+	}
+	return ret
+}
+
+func (c *Coverage) sortedFiles() (names []string, lines map[string][]int) {
+	lines = make(map[string][]int)
+	for name, counts := range c.files {
+		names = append(names, name)
+		for line := range counts {
+			lines[name] = append(lines[name], line)
+		}
+		sort.Ints(lines[name])
+	}
+	sort.Strings(names)
+	return
+}
+
+// WriteLCOV writes the coverage in lcov tracefile format
+func (c *Coverage) WriteLCOV(w io.Writer) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	bw := bufio.NewWriter(w)
+	names, lines := c.sortedFiles()
+	for _, name := range names {
+		counts := c.files[name]
+		fmt.Fprintf(bw, "TN:\nSF:%s\n", name)
+		hit := 0
+		for _, line := range lines[name] {
+			fmt.Fprintf(bw, "DA:%d,%d\n", line, counts[line])
+			if counts[line] > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", len(lines[name]), hit)
+	}
+	return bw.Flush()
+}
+
+// WriteGoCover writes the coverage in the format of go test -coverprofile with count mode.
+// each line is a block of one statement.
+func (c *Coverage) WriteGoCover(w io.Writer) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "mode: count\n")
+	names, lines := c.sortedFiles()
+	for _, name := range names {
+		counts := c.files[name]
+		for _, line := range lines[name] {
+			fmt.Fprintf(bw, "%s:%d.1,%d.0 1 %d\n", name, line, line+1, counts[line])
+		}
+	}
+	return bw.Flush()
+}
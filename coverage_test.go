@@ -0,0 +1,91 @@
+package lua
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	code := `
+local function f(n)
+  if n > 1 then
+    return 'large'
+  end
+  return 'small'
+end
+return f(N)
+`
+	run := func(cov *Coverage, n int) {
+		l, err := New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		l.SetCoverage(cov)
+		chunk, err := l.LoadString("cov.lua", code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := chunk.Run("N", n); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := l.Peval(`return 1`); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	small := NewCoverage()
+	run(small, 1)
+	lines := small.Lines("cov.lua")
+	if lines[3] != 1 || lines[6] != 1 || lines[8] == 0 {
+		t.Fatalf("bad lines %v", lines)
+	}
+	if count, ok := lines[4]; !ok || count != 0 {
+		t.Fatalf("line not reported %v", lines)
+	}
+
+	large := NewCoverage()
+	run(large, 2)
+	run(large, 3)
+	if lines := large.Lines("cov.lua"); lines[4] != 2 {
+		t.Fatalf("bad lines %v", lines)
+	}
+	large.Merge(small)
+	lines = large.Lines("cov.lua")
+	if lines[3] != 3 || lines[4] != 2 || lines[6] != 1 {
+		t.Fatalf("bad merged lines %v", lines)
+	}
+	// merging each other concurrently
+	for i := 0; i < 100; i++ {
+		a, b := NewCoverage(), NewCoverage()
+		done := make(chan struct{})
+		go func() {
+			a.Merge(b)
+			close(done)
+		}()
+		b.Merge(a)
+		<-done
+	}
+
+	buf := new(bytes.Buffer)
+	if err := large.WriteLCOV(buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "TN:\nSF:cov.lua\n") ||
+		!strings.Contains(out, "DA:4,2\n") ||
+		!strings.HasSuffix(out, "end_of_record\n") {
+		t.Fatalf("bad lcov %s", out)
+	}
+	buf.Reset()
+	if err := small.WriteGoCover(buf); err != nil {
+		t.Fatal(err)
+	}
+	out = buf.String()
+	if !strings.HasPrefix(out, "mode: count\n") ||
+		!strings.Contains(out, "cov.lua:4.1,5.0 1 0\n") ||
+		!strings.Contains(out, "cov.lua:6.1,7.0 1 1\n") {
+		t.Fatalf("bad go cover %s", out)
+	}
+}
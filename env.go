@@ -0,0 +1,282 @@
+package lua
+
+/*
+#include <lua.h>
+#include <lauxlib.h>
+*/
+import "C"
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// EnvMode chooses what code running in an Env sees besides the Env's variables
+type EnvMode int
+
+// env modes
+const (
+	// EnvReadThrough falls back to globals for reading. assignments to globals stay in the Env,
+	// but tables reached from globals are shared, so writes like string.foo = 1 still change them.
+	EnvReadThrough EnvMode = iota
+	// EnvIsolated has no access to globals. everything the code uses must be set in the Env.
+	EnvIsolated
+)
+
+// Env is a table of variables for running code in, reusable across Peval, Chunk.Run and DoFile.
+// pass it as the only env argument, like l.Peval(code, env).
+type Env struct {
+	lua      *Lua
+	ref      C.int
+	mode     EnvMode
+	name     string // empty for unnamed envs
+	parent   *Env
+	children []*Env
+}
+
+// NewEnv creates an empty Env
+func (l *Lua) NewEnv(mode EnvMode) *Env {
+	return l.newEnv(mode, nil)
+}
+
+// NewEnvironment creates a named Env reading through to parent, or globals if parent is nil.
+// named envs are kept until destroyed, and can be looked up by Environment.
+func (l *Lua) NewEnvironment(name string, parent *Env) (*Env, error) {
+	if name == "" {
+		return nil, fmt.Errorf("empty environment name")
+	}
+	if _, ok := l.environments[name]; ok {
+		return nil, fmt.Errorf("environment exists: %s", name)
+	}
+	if parent != nil && parent.ref == C.LUA_NOREF {
+		return nil, fmt.Errorf("parent environment is closed")
+	}
+	env := l.newEnv(EnvReadThrough, parent)
+	env.name = name
+	if l.environments == nil {
+		l.environments = make(map[string]*Env)
+	}
+	l.environments[name] = env
+	if parent != nil {
+		parent.children = append(parent.children, env)
+	}
+	return env, nil
+}
+
+// Environment returns the named Env, or nil if not exists
+func (l *Lua) Environment(name string) *Env {
+	return l.environments[name]
+}
+
+func (l *Lua) newEnv(mode EnvMode, parent *Env) *Env {
+	defer C.lua_settop(l.State, 0)
+	C.lua_createtable(l.State, 0, 0)
+	// _G refers to the env itself, so _G.foo = 1 stays in it
+	C.lua_pushvalue(l.State, -1)
+	C.lua_setfield(l.State, -2, cstr("_G"))
+	if mode == EnvReadThrough {
+		C.lua_createtable(l.State, 0, 1)
+		if parent != nil {
+			parent.push()
+		} else {
+			C.lua_pushvalue(l.State, C.LUA_GLOBALSINDEX)
+		}
+		C.lua_setfield(l.State, -2, cstr("__index"))
+		C.lua_setmetatable(l.State, -2)
+	}
+	return &Env{
+		lua:    l,
+		ref:    C.luaL_ref(l.State, C.LUA_REGISTRYINDEX),
+		mode:   mode,
+		parent: parent,
+	}
+}
+
+// Mode returns the mode of the env
+func (e *Env) Mode() EnvMode {
+	return e.mode
+}
+
+// Name returns the name of the env, empty for envs created by NewEnv
+func (e *Env) Name() string {
+	return e.name
+}
+
+// Parent returns the parent of the env, nil if it reads through to globals or is isolated
+func (e *Env) Parent() *Env {
+	return e.parent
+}
+
+func (e *Env) push() C.int {
+	C.lua_rawgeti(e.lua.State, C.LUA_REGISTRYINDEX, e.ref)
+	return C.lua_gettop(e.lua.State)
+}
+
+// Pset sets variables in the env like Lua.Pset. no panic when error occur.
+func (e *Env) Pset(args ...interface{}) error {
+	l := e.lua
+	defer C.lua_settop(l.State, 0)
+	root := e.push()
+	return eachNameValue(args, func(name string, value interface{}) error {
+		return l.setPath(root, true, name, value)
+	})
+}
+
+// Set sets variables in the env like Lua.Set. panic if error occur.
+func (e *Env) Set(args ...interface{}) {
+	if err := e.Pset(args...); err != nil {
+		panic(err)
+	}
+}
+
+// Pget gets variable defined in the env, not falling back to globals. no panic when error occur.
+func (e *Env) Pget(fullname string) (interface{}, error) {
+	l := e.lua
+	defer C.lua_settop(l.State, 0)
+	return l.getPath(e.push(), true, fullname)
+}
+
+// Get gets variable defined in the env, not falling back to globals. panic if error occur.
+func (e *Env) Get(fullname string) interface{} {
+	ret, err := e.Pget(fullname)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// GetInto gets variable defined in the env and converts it to the type dst points to
+func (e *Env) GetInto(fullname string, dst interface{}) error {
+	l := e.lua
+	defer C.lua_settop(l.State, 0)
+	return l.getPathInto(e.push(), true, fullname, dst)
+}
+
+// Has reports whether variable is defined in the env
+func (e *Env) Has(fullname string) bool {
+	l := e.lua
+	defer C.lua_settop(l.State, 0)
+	return l.hasPath(e.push(), true, fullname)
+}
+
+// Delete removes variable from the env
+func (e *Env) Delete(fullname string) error {
+	l := e.lua
+	defer C.lua_settop(l.State, 0)
+	return l.deletePath(e.push(), true, fullname)
+}
+
+// Names returns sorted names of variables defined in the env, for inspecting what code defined
+func (e *Env) Names() []string {
+	l := e.lua
+	defer C.lua_settop(l.State, 0)
+	root := e.push()
+	var names []string
+	C.lua_pushnil(l.State)
+	for C.lua_next(l.State, root) != 0 {
+		if C.lua_type(l.State, -2) == C.LUA_TSTRING {
+			name := C.GoString(C.lua_tolstring(l.State, -2, nil))
+			if name != "_G" {
+				names = append(names, name)
+			}
+		}
+		C.lua_settop(l.State, -2)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close releases the env. variables in it are kept alive by functions defined in it.
+// named environments are unregistered, so the name can be used again.
+func (e *Env) Close() {
+	if e.ref == C.LUA_NOREF {
+		return
+	}
+	l := e.lua
+	if e.name != "" && l.environments[e.name] == e {
+		delete(l.environments, e.name)
+	}
+	if e.parent != nil {
+		for i, child := range e.parent.children {
+			if child == e {
+				e.parent.children = append(e.parent.children[:i], e.parent.children[i+1:]...)
+				break
+			}
+		}
+	}
+	C.luaL_unref(l.State, C.LUA_REGISTRYINDEX, e.ref)
+	e.ref = C.LUA_NOREF
+}
+
+// Destroy removes all variables defined in the env and its children, then releases them.
+// everything they defined can be collected unless referenced from outside.
+func (e *Env) Destroy() {
+	if e.ref == C.LUA_NOREF {
+		return
+	}
+	children := e.children
+	e.children = nil
+	for _, child := range children {
+		child.Destroy()
+	}
+	l := e.lua
+	func() {
+		defer C.lua_settop(l.State, 0)
+		root := e.push()
+		C.lua_pushnil(l.State)
+		for C.lua_next(l.State, root) != 0 {
+			// assigning nil to existing fields is allowed during traversal
+			C.lua_settop(l.State, -2)
+			C.lua_pushvalue(l.State, -1)
+			C.lua_pushnil(l.State)
+			C.lua_rawset(l.State, root)
+		}
+	}()
+	e.Close()
+}
+
+// envPairs converts a map or struct env argument to name-value pairs
+func envPairs(envs []interface{}) ([]interface{}, error) {
+	if len(envs) != 1 || envs[0] == nil {
+		return envs, nil
+	}
+	value := reflect.ValueOf(envs[0])
+	if value.Kind() == reflect.Ptr && value.Elem().Kind() == reflect.Struct {
+		value = value.Elem()
+	}
+	var pairs []interface{}
+	switch value.Kind() {
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("name must be string, not %v", value.Type().Key())
+		}
+		var names []string
+		for _, key := range value.MapKeys() {
+			names = append(names, key.String())
+		}
+		// parents before children, so foo and foo.bar are both set
+		sort.Strings(names)
+		for _, name := range names {
+			pairs = append(pairs, name, value.MapIndex(reflect.ValueOf(name).Convert(value.Type().Key())).Interface())
+		}
+	case reflect.Struct:
+		valueType := value.Type()
+		for i := 0; i < valueType.NumField(); i++ {
+			field := valueType.Field(i)
+			if field.PkgPath != "" { // unexported
+				continue
+			}
+			name := field.Tag.Get("lua")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			pairs = append(pairs, name, value.Field(i).Interface())
+		}
+	default:
+		return envs, nil
+	}
+	return pairs, nil
+}
@@ -0,0 +1,274 @@
+package lua
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnv(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.Set("shared", 1)
+	env := l.NewEnv(EnvReadThrough)
+	defer env.Close()
+	if env.Mode() != EnvReadThrough {
+		t.Fatalf("bad mode")
+	}
+	env.Set("conf.limit", 10)
+	ret, err := l.Peval(`
+	count = (count or 0) + 1
+	_G.total = shared + conf.limit
+	return count
+	`, env)
+	if err != nil || ret[0].(float64) != 1 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	ret, err = l.Peval(`count = count + 1 return count, total`, env)
+	if err != nil || ret[0].(float64) != 2 || ret[1].(float64) != 11 {
+		t.Fatalf("env not reused %v %v", ret, err)
+	}
+	if l.Has("count") || l.Has("total") || l.Has("conf") {
+		t.Fatalf("env leaked to globals")
+	}
+	if names := env.Names(); !reflect.DeepEqual(names, []string{"conf", "count", "total"}) {
+		t.Fatalf("bad names %v", names)
+	}
+	if env.Get("count").(float64) != 2 || env.Has("shared") || !env.Has("conf.limit") {
+		t.Fatalf("bad env get")
+	}
+	var limit int
+	if err := env.GetInto("conf.limit", &limit); err != nil || limit != 10 {
+		t.Fatalf("bad env get %v %v", limit, err)
+	}
+	if err := env.Delete("count"); err != nil || env.Has("count") {
+		t.Fatalf("not deleted %v", err)
+	}
+
+	// chunk and file
+	chunk, err := l.Compile(`return conf.limit * 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chunk.Close()
+	ret, err = chunk.Run(env)
+	if err != nil || ret[0].(float64) != 20 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	env.Set("N", 1)
+	ret, err = l.DoFile("testdata/error.lua", env)
+	if err != nil || ret[0].(float64) != 1 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+
+	// isolated
+	isolated := l.NewEnv(EnvIsolated)
+	defer isolated.Close()
+	_, err = l.Peval(`return shared + 1`, isolated)
+	if err == nil || !strings.Contains(err.Error(), "shared") {
+		t.Fatalf("isolated env reads globals or error %v", err)
+	}
+	isolated.Set("shared", 41)
+	ret, err = l.Peval(`return shared + 1`, isolated)
+	if err != nil || ret[0].(float64) != 42 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	if l.Get("shared").(float64) != 1 {
+		t.Fatalf("isolated env leaked")
+	}
+
+	// errors
+	err = env.Pset("foo")
+	if err == nil || !strings.Contains(err.Error(), "number of arguments not match") {
+		t.Fatalf("allowing bad args or error %v", err)
+	}
+	func() {
+		defer func() {
+			if e := recover(); e == nil {
+				t.Fatalf("Set no panic")
+			}
+		}()
+		env.Set(42, 42)
+	}()
+	func() {
+		defer func() {
+			if e := recover(); e == nil {
+				t.Fatalf("Get no panic")
+			}
+		}()
+		env.Get("conf[")
+	}()
+}
+
+func TestEnvironment(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.Set("base", 1)
+	tenant, err := l.NewEnvironment("tenant", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tenant.Name() != "tenant" || tenant.Parent() != nil || l.Environment("tenant") != tenant {
+		t.Fatalf("bad environment")
+	}
+	user, err := l.NewEnvironment("tenant/user", tenant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Parent() != tenant {
+		t.Fatalf("bad parent")
+	}
+	_, err = l.Peval(`plan = 'pro' function limit() return base * 10 end`, tenant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := l.Peval(`name = 'foo' return plan, limit()`, user)
+	if err != nil || ret[0].(string) != "pro" || ret[1].(float64) != 10 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	if tenant.Has("name") || l.Has("plan") {
+		t.Fatalf("environment leaked")
+	}
+
+	// errors
+	if _, err := l.NewEnvironment("tenant", nil); err == nil || !strings.Contains(err.Error(), "exists") {
+		t.Fatalf("allowing duplicated name or error %v", err)
+	}
+	if _, err := l.NewEnvironment("", nil); err == nil {
+		t.Fatalf("allowing empty name")
+	}
+
+	// destroy
+	l.Eval(`function keep(f) kept = f end`)
+	l.Peval(`keep(function() return plan end)`, tenant)
+	if l.Call("kept")[0].(string) != "pro" {
+		t.Fatalf("bad return")
+	}
+	tenant.Destroy()
+	if l.Environment("tenant") != nil || l.Environment("tenant/user") != nil {
+		t.Fatalf("environment not destroyed")
+	}
+	if _, err := l.NewEnvironment("tenant/user", tenant); err == nil {
+		t.Fatalf("allowing destroyed parent")
+	}
+	// functions defined in the environment no longer see its variables
+	ret, err = l.Pcall("kept")
+	if err != nil || ret[0] != nil {
+		t.Fatalf("environment not cleared %v %v", ret, err)
+	}
+	// destroyed environments can't be used
+	if _, err := l.Peval(`return 1`, tenant); err == nil || !strings.Contains(err.Error(), "env is closed") {
+		t.Fatalf("allowing destroyed env %v", err)
+	}
+	chunk, err := l.Compile(`return 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chunk.Close()
+	if _, err := chunk.Run(tenant); err == nil || !strings.Contains(err.Error(), "env is closed") {
+		t.Fatalf("allowing destroyed env %v", err)
+	}
+	tenant, err = l.NewEnvironment("tenant", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tenant.Has("plan") {
+		t.Fatalf("environment not cleared")
+	}
+	tenant.Destroy()
+	tenant.Destroy()
+
+	// closing unregisters the name
+	closed, err := l.NewEnvironment("closed", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := l.NewEnvironment("closed/child", closed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child.Close()
+	if l.Environment("closed/child") != nil || len(closed.children) != 0 {
+		t.Fatalf("closed environment registered")
+	}
+	closed.Close()
+	if l.Environment("closed") != nil {
+		t.Fatalf("closed environment registered")
+	}
+	reopened, err := l.NewEnvironment("closed", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened.Close()
+}
+
+func TestEvalTypedEnvs(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// map
+	ret, err := l.Peval(`return a + b.c + b.d`, map[string]interface{}{
+		"a":   1,
+		"b.c": 2,
+		"b.d": 3,
+	})
+	if err != nil || ret[0].(float64) != 6 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	ret, err = l.Peval(`return a`, map[string]string{"a": "foo"})
+	if err != nil || ret[0].(string) != "foo" {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	_, err = l.Peval(`return 1`, map[int]int{1: 1})
+	if err == nil || !strings.Contains(err.Error(), "name must be string") {
+		t.Fatalf("allowing non-string names or error %v", err)
+	}
+
+	// struct
+	type Request struct {
+		Path    string `lua:"req.path"`
+		Method  string `lua:"req.method"`
+		Retries int
+		Secret  string `lua:"-"`
+		private int
+	}
+	req := Request{
+		Path:    "/",
+		Method:  "GET",
+		Retries: 3,
+		Secret:  "secret",
+		private: 1,
+	}
+	ret, err = l.Peval(`return req.method .. ' ' .. req.path, Retries, Secret, private`, req)
+	if err != nil || ret[0].(string) != "GET /" || ret[1].(float64) != 3 || ret[2] != nil || ret[3] != nil {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	ret, err = l.Peval(`return Retries`, &req)
+	if err != nil || ret[0].(float64) != 3 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	if l.Has("req") {
+		t.Fatalf("env leaked to globals")
+	}
+
+	// dotted names in pairs
+	ret, err = l.Peval(`return foo.bar`, "foo.bar", 42)
+	if err != nil || ret[0].(float64) != 42 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	_, err = l.Peval(`return 1`, "foo", 1, "foo.bar", 2)
+	if err == nil || !strings.Contains(err.Error(), "invalid namespace") {
+		t.Fatalf("allowing bad namespace or error %v", err)
+	}
+}
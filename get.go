@@ -0,0 +1,116 @@
+package lua
+
+/*
+#include <lua.h>
+*/
+import "C"
+import (
+	"fmt"
+	"reflect"
+)
+
+// Pget gets lua variable. names are paths like in Set. returns nil if not exists. no panic when error occur.
+func (l *Lua) Pget(fullname string) (interface{}, error) {
+	defer C.lua_settop(l.State, 0)
+	return l.getPath(C.LUA_GLOBALSINDEX, l.rawAccess, fullname)
+}
+
+// Get gets lua variable. panic if error occur.
+func (l *Lua) Get(fullname string) interface{} {
+	ret, err := l.Pget(fullname)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// GetInto gets lua variable and converts it to the type dst points to, like arguments of go functions
+func (l *Lua) GetInto(fullname string, dst interface{}) error {
+	defer C.lua_settop(l.State, 0)
+	return l.getPathInto(C.LUA_GLOBALSINDEX, l.rawAccess, fullname, dst)
+}
+
+// Has reports whether lua variable exists and is not nil
+func (l *Lua) Has(fullname string) bool {
+	defer C.lua_settop(l.State, 0)
+	return l.hasPath(C.LUA_GLOBALSINDEX, l.rawAccess, fullname)
+}
+
+// Delete sets lua variable to nil. namespaces are not created if not exist.
+func (l *Lua) Delete(fullname string) error {
+	defer C.lua_settop(l.State, 0)
+	return l.deletePath(C.LUA_GLOBALSINDEX, l.rawAccess, fullname)
+}
+
+func (l *Lua) getPath(root C.int, raw bool, fullname string) (interface{}, error) {
+	if err := l.pushPath(root, raw, fullname); err != nil {
+		return nil, err
+	}
+	value, err := l.toGoValue(-1, interfaceType)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, nil
+	}
+	return value.Interface(), nil
+}
+
+func (l *Lua) getPathInto(root C.int, raw bool, fullname string, dst interface{}) error {
+	ptr := reflect.ValueOf(dst)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		return fmt.Errorf("dst must be a non-nil pointer, not %v", dst)
+	}
+	if err := l.pushPath(root, raw, fullname); err != nil {
+		return err
+	}
+	elem := ptr.Elem()
+	value, err := l.toGoValue(-1, elem.Type())
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, fullname)
+	}
+	if value == nil {
+		elem.Set(reflect.Zero(elem.Type()))
+	} else {
+		elem.Set(*value)
+	}
+	return nil
+}
+
+func (l *Lua) hasPath(root C.int, raw bool, fullname string) bool {
+	if err := l.pushPath(root, raw, fullname); err != nil {
+		return false
+	}
+	return C.lua_type(l.State, -1) != C.LUA_TNIL
+}
+
+func (l *Lua) deletePath(root C.int, raw bool, fullname string) error {
+	segments, err := parsePath(fullname)
+	if err != nil {
+		return err
+	}
+	if err := l.pushSegments(root, raw, segments[:len(segments)-1], fullname); err != nil {
+		return err
+	}
+	if C.lua_type(l.State, -1) == C.LUA_TNIL {
+		return nil
+	}
+	if !l.isNamespace(raw) {
+		return fmt.Errorf("invalid namespace: %s", fullname)
+	}
+	l.pushKey(segments[len(segments)-1])
+	C.lua_pushnil(l.State)
+	if err := l.setIndex(raw); err != nil {
+		return fmt.Errorf("%v: %s", err, fullname)
+	}
+	return nil
+}
+
+// pushPath pushes the value named fullname starting from the table at root. pushes nil if a namespace not exists.
+func (l *Lua) pushPath(root C.int, raw bool, fullname string) error {
+	segments, err := parsePath(fullname)
+	if err != nil {
+		return err
+	}
+	return l.pushSegments(root, raw, segments, fullname)
+}
@@ -0,0 +1,114 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.Set("foo.bar.baz", 42, "s", "foobar")
+	if v := l.Get("foo.bar.baz"); v.(float64) != 42 {
+		t.Fatalf("foo.bar.baz is not 42")
+	}
+	if v := l.Get("s"); v.(string) != "foobar" {
+		t.Fatalf("s is not foobar")
+	}
+	v, err := l.Pget("foo.none.baz")
+	if err != nil || v != nil {
+		t.Fatalf("bad get %v %v", v, err)
+	}
+	_, err = l.Pget("s.foo")
+	if err == nil || !strings.Contains(err.Error(), "invalid namespace") {
+		t.Fatalf("allowing bad namespace or error %v", err)
+	}
+	_, err = l.Pget("foo.bar")
+	if err == nil || !strings.Contains(err.Error(), "unsupported type TABLE") {
+		t.Fatalf("allowing table or error %v", err)
+	}
+	func() {
+		defer func() {
+			if e := recover(); e == nil {
+				t.Fatalf("Get no panic")
+			}
+		}()
+		l.Get("s.foo")
+	}()
+
+	// typed
+	l.Eval(`conf = {ports = {80, 443}, names = {a = 'x', b = 'y'}}`)
+	var ports []int
+	if err := l.GetInto("conf.ports", &ports); err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 2 || ports[0] != 80 || ports[1] != 443 {
+		t.Fatalf("bad ports %v", ports)
+	}
+	var names map[string]string
+	if err := l.GetInto("conf.names", &names); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names["a"] != "x" || names["b"] != "y" {
+		t.Fatalf("bad names %v", names)
+	}
+	// nested
+	l.Eval(`conf.matrix = {{1, 2}, {3}}`)
+	var matrix [][]int
+	if err := l.GetInto("conf.matrix", &matrix); err != nil {
+		t.Fatal(err)
+	}
+	if len(matrix) != 2 || len(matrix[0]) != 2 || matrix[0][1] != 2 || matrix[1][0] != 3 {
+		t.Fatalf("bad matrix %v", matrix)
+	}
+	var groups map[string][]int
+	if err := l.GetInto("conf", &groups); err == nil {
+		t.Fatalf("allowing bad nested type")
+	}
+	l.Eval(`groups = {web = {80, 443}, db = {5432}}`)
+	if err := l.GetInto("groups", &groups); err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || len(groups["web"]) != 2 || groups["db"][0] != 5432 {
+		t.Fatalf("bad groups %v", groups)
+	}
+	var i interface{} = 1
+	if err := l.GetInto("conf.none", &i); err != nil || i != nil {
+		t.Fatalf("bad get %v %v", i, err)
+	}
+	var n int
+	err = l.GetInto("conf.names", &n)
+	if err == nil || !strings.Contains(err.Error(), "not an integer: conf.names") {
+		t.Fatalf("allowing bad type or error %v", err)
+	}
+	err = l.GetInto("conf.ports", n)
+	if err == nil || !strings.Contains(err.Error(), "non-nil pointer") {
+		t.Fatalf("allowing non-pointer or error %v", err)
+	}
+
+	// has and delete
+	if !l.Has("foo.bar.baz") || l.Has("foo.bar.none") || l.Has("none.bar") || l.Has("s.foo") {
+		t.Fatalf("bad has")
+	}
+	if err := l.Delete("foo.bar.baz"); err != nil {
+		t.Fatal(err)
+	}
+	if l.Has("foo.bar.baz") || !l.Has("foo.bar") {
+		t.Fatalf("not deleted")
+	}
+	if err := l.Delete("s"); err != nil || l.Has("s") {
+		t.Fatalf("not deleted %v", err)
+	}
+	if err := l.Delete("none.bar"); err != nil {
+		t.Fatal(err)
+	}
+	l.Set("s", "foobar")
+	err = l.Delete("s.foo")
+	if err == nil || !strings.Contains(err.Error(), "invalid namespace") {
+		t.Fatalf("allowing bad namespace or error %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package lua
+
+/*
+#include <lua.h>
+#include <stdlib.h>
+
+void set_hook(lua_State*, int, int);
+*/
+import "C"
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+// HookMask selects events reported to the hook function
+type HookMask int
+
+// hook events
+const (
+	HookCall   HookMask = C.LUA_MASKCALL
+	HookReturn HookMask = C.LUA_MASKRET
+	HookLine   HookMask = C.LUA_MASKLINE
+	HookCount  HookMask = C.LUA_MASKCOUNT // every count instructions
+)
+
+func (m HookMask) String() string {
+	switch m {
+	case HookCall:
+		return "call"
+	case HookReturn:
+		return "return"
+	case HookLine:
+		return "line"
+	case HookCount:
+		return "count"
+	}
+	return fmt.Sprintf("HookMask(%d)", int(m))
+}
+
+// HookEvent describes the running function when a hook event occurs
+type HookEvent struct {
+	Event           HookMask
+	Source          string // like @path/to/file.lua, or the code
+	ShortSource     string // printable version of Source
+	Line            int    // current line, -1 if not available
+	Name            string // function name, empty if not known
+	NameWhat        string // global, local, method, field, upvalue or empty
+	What            string // Lua, C, main or tail
+	LineDefined     int
+	LastLineDefined int
+	call            *hookCall
+}
+
+type hookCall struct {
+	state *C.lua_State
+	ar    *C.lua_Debug
+	abort *string
+}
+
+// hookEntry is one of the hooks of a vm, set by SetHook, SetCoverage or StartProfile
+type hookEntry struct {
+	mask    HookMask
+	count   int
+	fn      func(HookEvent)
+	counted int // instructions since the last count event
+}
+
+// hookSet holds the hooks of a vm, which share the lua hook
+type hookSet struct {
+	entries []*hookEntry // replaced, not modified, when changed
+	count   int          // count of the lua hook, the minimum of entries
+}
+
+var (
+	hooks     = make(map[*C.lua_State]*hookSet) // by main state
+	hooksLock sync.RWMutex
+	hookKey   = "reusee/lua.hook"
+)
+
+// SetHook sets fn to be called on events selected by mask. count is for HookCount.
+// a nil fn or zero mask removes the hook. it replaces the hook set before by SetHook,
+// and runs together with hooks of SetCoverage and StartProfile.
+// the hook function must not run lua code.
+func (l *Lua) SetHook(mask HookMask, count int, fn func(ev HookEvent)) {
+	main := l.mainLua()
+	l.removeHook(main.hook)
+	main.hook = l.addHook(mask, count, fn)
+}
+
+// addHook adds a hook to the vm, returns nil if it's empty
+func (l *Lua) addHook(mask HookMask, count int, fn func(ev HookEvent)) *hookEntry {
+	if fn == nil || mask == 0 {
+		return nil
+	}
+	if count <= 0 {
+		mask &^= HookCount
+		if mask == 0 {
+			return nil
+		}
+	}
+	entry := &hookEntry{
+		mask:  mask,
+		count: count,
+		fn:    fn,
+	}
+	l.updateHooks(func(entries []*hookEntry) []*hookEntry {
+		return append(entries, entry)
+	})
+	return entry
+}
+
+// removeHook removes the hook added by addHook, if it's not removed yet
+func (l *Lua) removeHook(entry *hookEntry) {
+	if entry == nil {
+		return
+	}
+	l.updateHooks(func(entries []*hookEntry) []*hookEntry {
+		for i, e := range entries {
+			if e == entry {
+				return append(entries[:i:i], entries[i+1:]...)
+			}
+		}
+		return entries
+	})
+}
+
+// updateHooks replaces the hooks of the vm, and sets the lua hook to cover all of them
+func (l *Lua) updateHooks(update func([]*hookEntry) []*hookEntry) {
+	main := l.mainLua()
+	hooksLock.Lock()
+	set := &hookSet{}
+	if old, ok := hooks[main.State]; ok {
+		set.entries = old.entries
+	}
+	set.entries = update(set.entries)
+	var mask HookMask
+	for _, entry := range set.entries {
+		mask |= entry.mask
+		if entry.mask&HookCount != 0 && (set.count == 0 || entry.count < set.count) {
+			set.count = entry.count
+		}
+		entry.counted = 0
+	}
+	if len(set.entries) == 0 {
+		delete(hooks, main.State)
+	} else {
+		hooks[main.State] = set
+	}
+	hooksLock.Unlock()
+	// hooks run in coroutines, which find the main state in the registry
+	C.lua_pushlightuserdata(l.State, unsafe.Pointer(main.State))
+	C.lua_setfield(l.State, C.LUA_REGISTRYINDEX, cstr(hookKey))
+	C.set_hook(l.State, C.int(mask), C.int(set.count))
+}
+
+func forgetHook(state *C.lua_State) {
+	hooksLock.Lock()
+	delete(hooks, state)
+	hooksLock.Unlock()
+}
+
+//export invokeGoHook
+func invokeGoHook(state *C.lua_State, ar *C.lua_Debug) *C.char {
+	C.lua_getfield(state, C.LUA_REGISTRYINDEX, cstr(hookKey))
+	main := (*C.lua_State)(C.lua_touserdata(state, -1))
+	C.lua_settop(state, -2)
+	hooksLock.RLock()
+	set := hooks[main]
+	hooksLock.RUnlock()
+	if set == nil {
+		return nil
+	}
+	ev := HookEvent{
+		Line: -1,
+		call: &hookCall{
+			state: state,
+			ar:    ar,
+		},
+	}
+	switch ar.event {
+	case C.LUA_HOOKCALL:
+		ev.Event = HookCall
+	case C.LUA_HOOKRET, C.LUA_HOOKTAILRET:
+		ev.Event = HookReturn
+	case C.LUA_HOOKLINE:
+		ev.Event = HookLine
+	case C.LUA_HOOKCOUNT:
+		ev.Event = HookCount
+	}
+	if C.lua_getinfo(state, cstr("nSl"), ar) != 0 {
+		ev.Source = C.GoString(ar.source)
+		ev.ShortSource = C.GoString(&ar.short_src[0])
+		ev.Line = int(ar.currentline)
+		if ar.name != nil {
+			ev.Name = C.GoString(ar.name)
+		}
+		ev.NameWhat = C.GoString(ar.namewhat)
+		ev.What = C.GoString(ar.what)
+		ev.LineDefined = int(ar.linedefined)
+		ev.LastLineDefined = int(ar.lastlinedefined)
+	}
+	for _, entry := range set.entries {
+		if entry.mask&ev.Event == 0 {
+			continue
+		}
+		if ev.Event == HookCount {
+			// the lua hook runs every set.count instructions
+			entry.counted += set.count
+			if entry.counted < entry.count {
+				continue
+			}
+			entry.counted -= entry.count
+		}
+		entry.fn(ev)
+		if ev.call.abort != nil {
+			// freed by the C hook before raising the error
+			return C.CString(*ev.call.abort)
+		}
+	}
+	return nil
+}
+
+// ActiveLines returns the lines having code in the running function, sorted.
+// it can only be called in the hook function.
+func (e HookEvent) ActiveLines() []int {
+	state := e.call.state
+	C.lua_getinfo(state, cstr("L"), e.call.ar)
+	defer C.lua_settop(state, -2)
+	if C.lua_type(state, -1) != C.LUA_TTABLE {
+		return nil
+	}
+	var lines []int
+	C.lua_pushnil(state)
+	for C.lua_next(state, -2) != 0 {
+		C.lua_settop(state, -2)
+		lines = append(lines, int(C.lua_tointeger(state, -1)))
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// Abort raises a lua error with msg in the running function after the hook function returns.
+// it can be used to stop scripts running too long.
+func (e HookEvent) Abort(msg string) {
+	e.call.abort = &msg
+}
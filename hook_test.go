@@ -0,0 +1,147 @@
+package lua
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHook(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	chunk, err := l.LoadString("hook.lua", `
+local function add(a, b)
+  return a + b
+end
+return add(1, 2)
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chunk.Close()
+
+	var lines []int
+	var calls []string
+	var activeLines []int
+	l.SetHook(HookCall|HookLine, 0, func(ev HookEvent) {
+		if ev.Source != "@hook.lua" {
+			return
+		}
+		if ev.ShortSource != "hook.lua" {
+			t.Fatalf("bad short source %s", ev.ShortSource)
+		}
+		switch ev.Event {
+		case HookLine:
+			lines = append(lines, ev.Line)
+		case HookCall:
+			calls = append(calls, ev.Name)
+			if ev.Name == "add" {
+				if ev.What != "Lua" || ev.LineDefined != 2 || ev.LastLineDefined != 4 {
+					t.Fatalf("bad event %+v", ev)
+				}
+				activeLines = ev.ActiveLines()
+			}
+		}
+	})
+	ret, err := chunk.Run()
+	if err != nil || ret[0].(float64) != 3 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	if !containsInts(lines, 3, 5) || containsInts(lines, 1) {
+		t.Fatalf("bad lines %v", lines)
+	}
+	if !reflect.DeepEqual(calls, []string{"", "add"}) {
+		t.Fatalf("bad calls %v", calls)
+	}
+	if !containsInts(activeLines, 3) || containsInts(activeLines, 5) {
+		t.Fatalf("bad active lines %v", activeLines)
+	}
+
+	// abort
+	n := 0
+	l.SetHook(HookCount, 1000, func(ev HookEvent) {
+		n++
+		if n > 10 {
+			ev.Abort("too long")
+		}
+	})
+	_, err = l.Peval(`while true do end`)
+	if err == nil || !strings.Contains(err.Error(), "too long") {
+		t.Fatalf("not aborted %v", err)
+	}
+
+	// remove
+	l.SetHook(0, 0, nil)
+	n = 0
+	if _, err := l.Peval(`for i = 1, 100000 do end`); err != nil || n != 0 {
+		t.Fatalf("hook not removed %v %d", err, n)
+	}
+
+	// hooks run together
+	p, err := l.StartProfile(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cov := NewCoverage()
+	l.SetCoverage(cov)
+	n = 0
+	l.SetHook(HookCount, 1000, func(HookEvent) {
+		n++
+	})
+	loop := `
+local s = 0
+for i = 1, 100000 do
+  s = s + i
+end
+return s
+`
+	if _, err := l.DoReader("loop.lua", strings.NewReader(loop)); err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 || cov.Lines("loop.lua")[4] == 0 {
+		t.Fatalf("hooks not run %d %v", n, cov.Lines("loop.lua"))
+	}
+	// the smaller count runs more often
+	_, counts := p.Top()
+	var samples int64
+	for _, count := range counts {
+		samples += count
+	}
+	if samples < int64(n) {
+		t.Fatalf("bad counts %d %d", samples, n)
+	}
+
+	// removing one keeps the others
+	p.Stop()
+	l.SetCoverage(nil)
+	n = 0
+	recorded := cov.Lines("loop.lua")[4]
+	if _, err := l.DoReader("loop.lua", strings.NewReader(loop)); err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 || cov.Lines("loop.lua")[4] != recorded {
+		t.Fatalf("bad hooks after removing %d %v", n, cov.Lines("loop.lua"))
+	}
+	l.SetHook(0, 0, nil)
+	n = 0
+	if _, err := l.DoReader("loop.lua", strings.NewReader(loop)); err != nil || n != 0 {
+		t.Fatalf("hook not removed %v %d", err, n)
+	}
+}
+
+func containsInts(s []int, ints ...int) bool {
+loop:
+	for _, i := range ints {
+		for _, e := range s {
+			if e == i {
+				continue loop
+			}
+		}
+		return false
+	}
+	return true
+}
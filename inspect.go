@@ -0,0 +1,243 @@
+package lua
+
+/*
+#include <lua.h>
+#include <stdint.h>
+
+int64_t go_func_id(lua_State*, int);
+*/
+import "C"
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// Pinspect renders the lua variable in lua-like syntax for debugging.
+// tables deeper than depth are shown as {...}, depth 0 means no limit.
+// cycles are shown as <cycle>, metatables as a <metatable> field.
+func (l *Lua) Pinspect(fullname string, depth int) (string, error) {
+	defer C.lua_settop(l.State, 0)
+	if err := l.pushPath(C.LUA_GLOBALSINDEX, l.rawAccess, fullname); err != nil {
+		return "", err
+	}
+	return l.inspectIndex(C.lua_gettop(l.State), depth), nil
+}
+
+// Inspect renders the lua variable like Pinspect. panic if error occur.
+func (l *Lua) Inspect(fullname string, depth int) string {
+	ret, err := l.Pinspect(fullname, depth)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// PinspectValue renders a go value as lua sees it after converting, like Pinspect.
+func (l *Lua) PinspectValue(v interface{}, depth int) (string, error) {
+	defer C.lua_settop(l.State, 0)
+	if err := l.pushGoValue(v, ""); err != nil {
+		return "", err
+	}
+	return l.inspectIndex(C.lua_gettop(l.State), depth), nil
+}
+
+// InspectValue renders a go value like PinspectValue. panic if error occur.
+func (l *Lua) InspectValue(v interface{}, depth int) string {
+	ret, err := l.PinspectValue(v, depth)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// inspectIndex renders the value at the absolute index i
+func (l *Lua) inspectIndex(i C.int, depth int) string {
+	if depth <= 0 {
+		depth = -1 // never reaches 0
+	}
+	buf := new(strings.Builder)
+	l.inspect(i, depth, make(map[unsafe.Pointer]bool), "", buf)
+	return buf.String()
+}
+
+type inspectEntry struct {
+	typ   C.int
+	num   float64
+	str   string
+	key   string
+	value string
+}
+
+// inspect writes the value at the absolute index i
+func (l *Lua) inspect(i C.int, depth int, seen map[unsafe.Pointer]bool, indent string, buf *strings.Builder) {
+	switch C.lua_type(l.State, i) {
+	case C.LUA_TNIL:
+		buf.WriteString("nil")
+	case C.LUA_TBOOLEAN:
+		buf.WriteString(strconv.FormatBool(C.lua_toboolean(l.State, i) == 1))
+	case C.LUA_TNUMBER:
+		buf.WriteString(formatNumber(float64(C.lua_tonumber(l.State, i))))
+	case C.LUA_TSTRING:
+		buf.WriteString(strconv.Quote(l.toString(i)))
+	case C.LUA_TTABLE:
+		l.inspectTable(i, depth, seen, indent, buf)
+	case C.LUA_TFUNCTION:
+		if id := C.go_func_id(l.State, i); id >= 0 {
+			funcsLock.RLock()
+			function := funcs[id]
+			funcsLock.RUnlock()
+			fmt.Fprintf(buf, "<go function %s %s>", function.name, function.funcType)
+			break
+		}
+		var ar C.lua_Debug
+		C.lua_pushvalue(l.State, i)
+		C.lua_getinfo(l.State, cstr(">S"), &ar)
+		if C.GoString(ar.what) == "C" {
+			fmt.Fprintf(buf, "<function [C] %p>", C.lua_topointer(l.State, i))
+		} else {
+			fmt.Fprintf(buf, "<function %s:%d>", C.GoString(&ar.short_src[0]), ar.linedefined)
+		}
+	case C.LUA_TUSERDATA:
+		fmt.Fprintf(buf, "<userdata %p", C.lua_topointer(l.State, i))
+		if C.lua_getmetatable(l.State, i) != 0 {
+			buf.WriteString(" ")
+			l.inspect(C.lua_gettop(l.State), depth, seen, indent, buf)
+			C.lua_settop(l.State, -2)
+		}
+		buf.WriteString(">")
+	case C.LUA_TLIGHTUSERDATA:
+		// go types are not shown, the pointed values may be collected and the addresses reused
+		fmt.Fprintf(buf, "<lightuserdata %p>", C.lua_topointer(l.State, i))
+	case C.LUA_TTHREAD:
+		fmt.Fprintf(buf, "<thread %p>", C.lua_topointer(l.State, i))
+	}
+}
+
+func (l *Lua) inspectTable(i C.int, depth int, seen map[unsafe.Pointer]bool, indent string, buf *strings.Builder) {
+	ptr := unsafe.Pointer(C.lua_topointer(l.State, i))
+	if seen[ptr] {
+		buf.WriteString("<cycle>")
+		return
+	}
+	if depth == 0 {
+		buf.WriteString("{...}")
+		return
+	}
+	seen[ptr] = true
+	defer delete(seen, ptr)
+	inner := indent + "  "
+
+	var items []string
+	var entries []inspectEntry
+	n := float64(C.lua_objlen(l.State, i))
+	C.lua_pushnil(l.State)
+	for C.lua_next(l.State, i) != 0 {
+		entry := inspectEntry{
+			typ: C.lua_type(l.State, -2),
+		}
+		value := new(strings.Builder)
+		l.inspect(C.lua_gettop(l.State), depth-1, seen, inner, value)
+		entry.value = value.String()
+		switch entry.typ {
+		case C.LUA_TNUMBER:
+			entry.num = float64(C.lua_tonumber(l.State, -2))
+			entry.key = "[" + formatNumber(entry.num) + "]"
+		case C.LUA_TSTRING:
+			entry.str = l.toString(-2)
+			if isIdentifier(entry.str) {
+				entry.key = entry.str
+			} else {
+				entry.key = "[" + strconv.Quote(entry.str) + "]"
+			}
+		default:
+			key := new(strings.Builder)
+			l.inspect(C.lua_gettop(l.State)-1, depth-1, seen, inner, key)
+			entry.str = key.String()
+			entry.key = "[" + entry.str + "]"
+		}
+		entries = append(entries, entry)
+		C.lua_settop(l.State, -2)
+	}
+	// array part first, then other keys ordered by type and value
+	sort.Slice(entries, func(a, b int) bool {
+		ea, eb := entries[a], entries[b]
+		if ea.typ != eb.typ {
+			return ea.typ == C.LUA_TNUMBER || ea.typ == C.LUA_TSTRING && eb.typ != C.LUA_TNUMBER
+		}
+		if ea.typ == C.LUA_TNUMBER {
+			return ea.num < eb.num
+		}
+		return ea.str < eb.str
+	})
+	for _, entry := range entries {
+		if entry.typ == C.LUA_TNUMBER && entry.num >= 1 && entry.num <= n && entry.num == float64(int64(entry.num)) {
+			items = append(items, entry.value)
+		} else {
+			items = append(items, entry.key+" = "+entry.value)
+		}
+	}
+	if C.lua_getmetatable(l.State, i) != 0 {
+		value := new(strings.Builder)
+		l.inspect(C.lua_gettop(l.State), depth-1, seen, inner, value)
+		items = append(items, "<metatable> = "+value.String())
+		C.lua_settop(l.State, -2)
+	}
+
+	if len(items) == 0 {
+		buf.WriteString("{}")
+		return
+	}
+	// one line if short
+	oneLine := "{" + strings.Join(items, ", ") + "}"
+	if len(indent)+len(oneLine) <= 80 && !strings.Contains(oneLine, "\n") {
+		buf.WriteString(oneLine)
+		return
+	}
+	buf.WriteString("{\n")
+	for _, item := range items {
+		buf.WriteString(inner + item + ",\n")
+	}
+	buf.WriteString(indent + "}")
+}
+
+func (l *Lua) toString(i C.int) string {
+	var length C.size_t
+	str := C.lua_tolstring(l.State, i, &length)
+	return C.GoStringN(str, C.int(length))
+}
+
+// formatNumber formats like lua's tostring, or as expressions for infinities and nan
+func formatNumber(n float64) string {
+	switch {
+	case math.IsInf(n, 1):
+		return "math.huge"
+	case math.IsInf(n, -1):
+		return "-math.huge"
+	case math.IsNaN(n):
+		return "0/0"
+	}
+	return strconv.FormatFloat(n, 'g', 14, 64)
+}
+
+func isIdentifier(s string) bool {
+	if s == "" || luaKeywords[s] {
+		return false
+	}
+	for i, c := range s {
+		if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+var luaKeywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true,
+	"false": true, "for": true, "function": true, "if": true, "in": true, "local": true,
+	"nil": true, "not": true, "or": true, "repeat": true, "return": true, "then": true,
+	"true": true, "until": true, "while": true,
+}
@@ -0,0 +1,115 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.Set("hello", func(name string) string {
+		return "hello, " + name
+	})
+	chunk, err := l.LoadString("inspect.lua", `
+t = {1, 2.5, 'three', name = 'foo', ['a-b'] = true, [10] = 'ten', ['end'] = 0}
+nested = {a = {b = {c = {d = 1}}}}
+cycle = {}
+cycle.self = cycle
+shared = {}
+twice = {shared, shared}
+with_meta = setmetatable({}, {__index = {x = 1}})
+function f()
+end
+long = {}
+for i = 1, 20 do
+  long[i] = 'item' .. i
+end
+inf = {math.huge, -math.huge}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chunk.Close()
+	if _, err := chunk.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name     string
+		depth    int
+		expected string
+	}{
+		{"t", 0, `{1, 2.5, "three", [10] = "ten", ["a-b"] = true, ["end"] = 0, name = "foo"}`},
+		{"nested", 0, `{a = {b = {c = {d = 1}}}}`},
+		{"nested", 2, `{a = {b = {...}}}`},
+		{"cycle", 0, `{self = <cycle>}`},
+		{"twice", 0, `{{}, {}}`},
+		{"with_meta", 0, `{<metatable> = {__index = {x = 1}}}`},
+		{"f", 0, `<function inspect.lua:9>`},
+		{"inf", 0, `{math.huge, -math.huge}`},
+		{"nothing", 0, `nil`},
+		{"t.name", 0, `"foo"`},
+	} {
+		if got := l.Inspect(c.name, c.depth); got != c.expected {
+			t.Fatalf("%s: got %s", c.name, got)
+		}
+	}
+
+	if got := l.Inspect("hello", 0); !strings.HasPrefix(got, "<go function hello func(string) string") {
+		t.Fatalf("bad go function %s", got)
+	}
+	if got := l.Inspect("print", 0); !strings.HasPrefix(got, "<function [C]") {
+		t.Fatalf("bad builtin %s", got)
+	}
+	got := l.Inspect("long", 0)
+	if !strings.HasPrefix(got, "{\n  \"item1\",\n") || !strings.HasSuffix(got, "\"item20\",\n}") {
+		t.Fatalf("bad multi-line %s", got)
+	}
+	if _, err := l.Pinspect("t[", 0); err == nil {
+		t.Fatalf("should fail")
+	}
+
+	// go values
+	type point struct{ X, Y int }
+	if got := l.InspectValue(map[string][]int{"a": {1, 2}}, 0); got != `{a = {1, 2}}` {
+		t.Fatalf("bad go value %s", got)
+	}
+	if _, err := l.PinspectValue(point{1, 2}, 0); err == nil {
+		t.Fatalf("allowing unsupported type")
+	}
+
+	// arguments
+	var arg string
+	l.Set("show", func(c *CallContext) int {
+		arg = c.Inspect(1, 1) + " " + c.Inspect(2, 0)
+		return 0
+	})
+	l.Eval(`show({a = {1}})`)
+	if arg != `{a = {...}} nil` {
+		t.Fatalf("bad argument %s", arg)
+	}
+
+	// results
+	chunk, err = l.Compile(`return 1, {f = print}, nil`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chunk.Close()
+	values, err := chunk.RunInspect(0)
+	if err != nil || len(values) != 3 || values[0] != "1" || !strings.HasPrefix(values[1], "{f = <function [C]") || values[2] != "nil" {
+		t.Fatalf("bad results %q %v", values, err)
+	}
+	chunk, err = l.Compile(`error('oops')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chunk.Close()
+	if _, err := chunk.RunInspect(0); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Fatalf("no error %v", err)
+	}
+}
@@ -1,21 +1,45 @@
 #include "lua.h"
 #include <lauxlib.h>
+#include <lualib.h>
 #include <stdlib.h>
 #include <stdio.h>
 #include <string.h>
 #include "_cgo_export.h"
 
+int call_go_func(lua_State *l) {
+  int ret = invokeGoFunc(l);
+  if (ret == GO_FUNC_ERROR) { // raise the message at top, with position like luaL_error
+    luaL_where(l, 1);
+    lua_insert(l, -2);
+    lua_concat(l, 2);
+    return lua_error(l);
+  }
+  if (ret < 0) { // yield returned values
+    return lua_yield(l, -ret - 1);
+  }
+  return ret;
+}
+
 void push_go_func(lua_State *l, int64_t id) {
   lua_pushinteger(l, id);
-  lua_pushcclosure(l, (lua_CFunction)invokeGoFunc, 1);
+  lua_pushcclosure(l, call_go_func, 1);
 }
 
 int traceback(lua_State *l) {
-  lua_pushstring(l, "\n"); // separate error message and traceback
   lua_getfield(l, LUA_GLOBALSINDEX, "debug");
+  if (!lua_istable(l, -1)) { // debug library not opened
+    lua_settop(l, 1);
+    return 1;
+  }
   lua_getfield(l, -1, "traceback");
-  lua_call(l, 0, 1);
+  if (!lua_isfunction(l, -1)) {
+    lua_settop(l, 1);
+    return 1;
+  }
   lua_remove(l, -2); // remove debug table from stack
+  lua_pushstring(l, "\n"); // separate error message and traceback
+  lua_insert(l, -2);
+  lua_call(l, 0, 1);
   lua_concat(l, 3); // concat origin error message
   return 1;
 }
@@ -24,47 +48,59 @@ void push_errfunc(lua_State *l) {
   lua_pushcfunction(l, traceback);
 }
 
-lua_State* new_state() {
-  lua_State *state = luaL_newstate();
-  if (state == NULL) {
-    return NULL;
-  }
-  luaL_openlibs(state);
-  return state;
+static int index_value(lua_State *l) {
+  lua_gettable(l, 1);
+  return 1;
 }
 
-char* ensure_name(lua_State *l, char *fullname) {
-  char *name, *next, *p = fullname;
-  int type;
+void push_index_func(lua_State *l) {
+  lua_pushcfunction(l, index_value);
+}
 
-  lua_getfield(l, LUA_GLOBALSINDEX, "_G");
+static int newindex_value(lua_State *l) {
+  lua_settable(l, 1);
+  return 0;
+}
 
-  name = strtok(fullname, ".");
-  next = strtok(NULL, ".");
-  while (name != NULL) {
-    if (next == NULL) { // variable name
-      lua_pushstring(l, name); // push as key
-    } else { // namespace
-      lua_pushstring(l, name);
-      lua_rawget(l, -2);
-      type = lua_type(l, -1);
-      if (type == LUA_TNIL) { // not exists, create new
-        lua_settop(l, -2);
-        lua_pushstring(l, name);
-        lua_createtable(l, 0, 0);
-        lua_rawset(l, -3);
-        lua_pushstring(l, name);
-        lua_rawget(l, -2);
-      } else if (type != LUA_TTABLE) { // not a table
-        return "invalid namespace";
-      }
+void push_newindex_func(lua_State *l) {
+  lua_pushcfunction(l, newindex_value);
+}
+
+static const luaL_Reg std_libs[] = {
+  {"base", luaopen_base},
+  {LUA_LOADLIBNAME, luaopen_package},
+  {LUA_TABLIBNAME, luaopen_table},
+  {LUA_IOLIBNAME, luaopen_io},
+  {LUA_OSLIBNAME, luaopen_os},
+  {LUA_STRLIBNAME, luaopen_string},
+  {LUA_MATHLIBNAME, luaopen_math},
+  {LUA_DBLIBNAME, luaopen_debug},
+  {LUA_BITLIBNAME, luaopen_bit},
+  {LUA_JITLIBNAME, luaopen_jit},
+  {LUA_FFILIBNAME, luaopen_ffi},
+  {NULL, NULL}
+};
+
+int open_lib(lua_State *l, const char *name) {
+  const luaL_Reg *lib;
+  for (lib = std_libs; lib->func; lib++) {
+    if (strcmp(lib->name, name) == 0) {
+      lua_pushcfunction(l, lib->func);
+      lua_pushstring(l, lib->func == luaopen_base ? "" : name);
+      lua_call(l, 1, 0);
+      return 0;
     }
-    name = next;
-    next = strtok(NULL, ".");
   }
+  return -1;
+}
 
-  free(p);
-  return NULL;
+lua_State* new_state() {
+  lua_State *state = luaL_newstate();
+  if (state == NULL) {
+    return NULL;
+  }
+  luaL_openlibs(state);
+  return state;
 }
 
 void set_eval_env(lua_State *l) {
@@ -77,3 +113,239 @@ void set_eval_env(lua_State *l) {
   // set function env
   lua_setfenv(l, -2);
 }
+
+typedef struct {
+  char *data;
+  size_t len;
+  size_t cap;
+} dump_buffer;
+
+static int dump_writer(lua_State *l, const void *p, size_t sz, void *ud) {
+  dump_buffer *buf = (dump_buffer*)ud;
+  char *data;
+  if (buf->len + sz > buf->cap) {
+    size_t cap = buf->cap * 2 + sz;
+    data = realloc(buf->data, cap);
+    if (data == NULL) {
+      return 1;
+    }
+    buf->data = data;
+    buf->cap = cap;
+  }
+  memcpy(buf->data + buf->len, p, sz);
+  buf->len += sz;
+  return 0;
+}
+
+int dump_function(lua_State *l, char **data, size_t *len) {
+  dump_buffer buf = {NULL, 0, 0};
+  int ret = lua_dump(l, dump_writer, &buf);
+  *data = buf.data;
+  *len = buf.len;
+  return ret;
+}
+
+static void hook(lua_State *l, lua_Debug *ar) {
+  char *msg = invokeGoHook(l, ar);
+  if (msg != NULL) {
+    lua_pushstring(l, msg);
+    free(msg);
+    lua_error(l);
+  }
+}
+
+void set_hook(lua_State *l, int mask, int count) {
+  lua_sethook(l, mask ? hook : NULL, mask, count);
+}
+
+int64_t go_func_id(lua_State *l, int idx) {
+  int64_t id;
+  if (lua_tocfunction(l, idx) != call_go_func) {
+    return -1;
+  }
+  lua_getupvalue(l, idx, 1);
+  id = lua_tointeger(l, -1);
+  lua_settop(l, -2);
+  return id;
+}
+
+// loaders refusing binary chunks, see RefuseBinaryChunks
+
+static const char *loader_names[] = {"loadstring", "load", "loadfile", "dofile", NULL};
+static const char *loaders_key = "reusee/lua.loaders";
+
+// loads code as text. returns the number of results like loadstring
+static int load_text(lua_State *l, const char *code, size_t size, const char *name) {
+  if (size > 0 && code[0] == LUA_SIGNATURE[0]) {
+    lua_pushnil(l);
+    lua_pushfstring(l, "binary chunk refused: %s", name);
+    return 2;
+  }
+  if (luaL_loadbuffer(l, code, size, name) != 0) {
+    lua_pushnil(l);
+    lua_insert(l, -2);
+    return 2;
+  }
+  return 1;
+}
+
+static int text_loadstring(lua_State *l) {
+  size_t size;
+  const char *code = luaL_checklstring(l, 1, &size);
+  const char *name = luaL_optstring(l, 2, code);
+  return load_text(l, code, size, name);
+}
+
+// appends size bytes to buf like dump_writer, returns 0 if out of memory
+static int append(dump_buffer *buf, const char *p, size_t size) {
+  return dump_writer(NULL, p, size, buf) == 0;
+}
+
+static int text_load(lua_State *l) {
+  dump_buffer buf = {NULL, 0, 0};
+  const char *name;
+  int ret;
+  if (lua_type(l, 1) == LUA_TSTRING) { // luajit accepts strings like loadstring
+    return text_loadstring(l);
+  }
+  name = luaL_optstring(l, 2, "=(load)");
+  luaL_checktype(l, 1, LUA_TFUNCTION);
+  lua_settop(l, 2);
+  for (;;) {
+    const char *piece;
+    size_t size;
+    lua_pushvalue(l, 1);
+    if (lua_pcall(l, 0, 1, 0) != 0) {
+      free(buf.data);
+      lua_pushnil(l);
+      lua_insert(l, -2);
+      return 2;
+    }
+    if (lua_isnil(l, -1)) {
+      break;
+    }
+    if (!lua_isstring(l, -1)) {
+      free(buf.data);
+      lua_pushnil(l);
+      lua_pushstring(l, "reader function must return a string");
+      return 2;
+    }
+    piece = lua_tolstring(l, -1, &size);
+    if (size == 0) {
+      break;
+    }
+    if (!append(&buf, piece, size)) {
+      free(buf.data);
+      return luaL_error(l, "not enough memory");
+    }
+    lua_settop(l, 2);
+  }
+  // copied by lua, so errors raised after this don't leak buf
+  lua_pushlstring(l, buf.data ? buf.data : "", buf.len);
+  free(buf.data);
+  {
+    size_t size;
+    const char *code = lua_tolstring(l, -1, &size);
+    ret = load_text(l, code, size, name);
+  }
+  return ret;
+}
+
+static int text_loadfile(lua_State *l) {
+  const char *path = luaL_optstring(l, 1, NULL);
+  FILE *f = path ? fopen(path, "rb") : stdin;
+  dump_buffer buf = {NULL, 0, 0};
+  char chunk[4096];
+  size_t n;
+  const char *code;
+  size_t size;
+  if (f == NULL) {
+    lua_pushnil(l);
+    lua_pushfstring(l, "cannot open %s", path);
+    return 2;
+  }
+  while ((n = fread(chunk, 1, sizeof(chunk), f)) > 0) {
+    if (!append(&buf, chunk, n)) {
+      break;
+    }
+  }
+  if (ferror(f) || n > 0) {
+    free(buf.data);
+    if (path) {
+      fclose(f);
+    }
+    lua_pushnil(l);
+    lua_pushfstring(l, "cannot read %s", path ? path : "stdin");
+    return 2;
+  }
+  if (path) {
+    fclose(f);
+  }
+  lua_pushlstring(l, buf.data ? buf.data : "", buf.len);
+  free(buf.data);
+  if (path) {
+    lua_pushfstring(l, "@%s", path);
+  } else {
+    lua_pushstring(l, "=stdin");
+  }
+  code = lua_tolstring(l, -2, &size);
+  // skip the first line if it starts with #, keeping the newline for line numbers, like luaL_loadfile
+  if (size > 0 && code[0] == '#') {
+    const char *newline = memchr(code, '\n', size);
+    size_t skip = newline ? (size_t)(newline - code) : size;
+    code += skip;
+    size -= skip;
+  }
+  return load_text(l, code, size, lua_tostring(l, -1));
+}
+
+static int text_dofile(lua_State *l) {
+  int base;
+  lua_settop(l, 1);
+  if (text_loadfile(l) != 1) {
+    return lua_error(l);
+  }
+  base = lua_gettop(l) - 1;
+  lua_call(l, 0, LUA_MULTRET);
+  return lua_gettop(l) - base;
+}
+
+static const lua_CFunction text_loaders[] = {text_loadstring, text_load, text_loadfile, text_dofile};
+
+// replaces the global loaders with ones refusing binary chunks, or restores them.
+// the originals are kept in the registry. only loaders present in globals are replaced.
+void refuse_binary_loaders(lua_State *l, int refuse) {
+  int i;
+  lua_getfield(l, LUA_REGISTRYINDEX, loaders_key);
+  if (lua_isnil(l, -1)) {
+    if (!refuse) {
+      lua_settop(l, -2);
+      return;
+    }
+    lua_settop(l, -2);
+    lua_createtable(l, 0, 4);
+    for (i = 0; loader_names[i]; i++) {
+      lua_getfield(l, LUA_GLOBALSINDEX, loader_names[i]);
+      lua_setfield(l, -2, loader_names[i]);
+    }
+    lua_pushvalue(l, -1);
+    lua_setfield(l, LUA_REGISTRYINDEX, loaders_key);
+  }
+  for (i = 0; loader_names[i]; i++) {
+    lua_getfield(l, -1, loader_names[i]);
+    if (!lua_isnil(l, -1)) {
+      if (refuse) {
+        lua_pushcfunction(l, text_loaders[i]);
+      } else {
+        lua_pushvalue(l, -1);
+      }
+      lua_setfield(l, LUA_GLOBALSINDEX, loader_names[i]);
+    }
+    lua_settop(l, -2);
+  }
+  if (!refuse) {
+    lua_pushnil(l);
+    lua_setfield(l, LUA_REGISTRYINDEX, loaders_key);
+  }
+  lua_settop(l, -2);
+}
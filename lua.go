@@ -8,11 +8,16 @@ package lua
 #include <stdlib.h>
 #include <stdint.h>
 
+// returned by invokeGoFunc to raise the error message at the top of the stack
+#define GO_FUNC_ERROR (-0x7fffffff)
+
 void push_go_func(lua_State*, int64_t);
 void push_errfunc(lua_State*);
+void push_index_func(lua_State*);
+void push_newindex_func(lua_State*);
 
 lua_State* new_state();
-char* ensure_name(lua_State*, char*);
+int open_lib(lua_State*, const char*);
 void set_eval_env(lua_State*);
 
 #cgo pkg-config: luajit
@@ -21,7 +26,6 @@ import "C"
 import (
 	"fmt"
 	"reflect"
-	"strings"
 	"sync"
 	"unsafe"
 )
@@ -30,6 +34,16 @@ import (
 type Lua struct {
 	State *C.lua_State
 	err   error
+	main  *Lua // set on views of coroutine states
+	// coroutines able to run async functions, see Scheduler
+	asyncThreads map[*C.lua_State]*Thread
+	evalCache    *chunkCache
+	refuseBinary bool
+	rawAccess    bool
+	environments map[string]*Env
+	coerce       bool       // convert numbers and strings for go function arguments
+	hook         *hookEntry // set by SetHook
+	coverageHook *hookEntry // set by SetCoverage
 }
 
 type _Function struct {
@@ -38,7 +52,28 @@ type _Function struct {
 	fun       interface{}
 	funcType  reflect.Type
 	funcValue reflect.Value
-	argc      int
+	argc      int // number of lua arguments
+	minArgc   int // trailing arguments can be omitted, see Optional and Defaults
+	defaults  []reflect.Value
+	async     bool
+	callInfo  bool // *CallInfo as the first parameter
+	coerce    bool
+}
+
+// Function wraps a go function with options. it can be set like a plain function.
+type Function struct {
+	fun      interface{}
+	async    bool
+	defaults []interface{}
+	coerce   bool
+}
+
+func asFunction(fn interface{}) *Function {
+	if f, ok := fn.(*Function); ok {
+		copied := *f
+		return &copied
+	}
+	return &Function{fun: fn}
 }
 
 var newState = func() *C.lua_State {
@@ -66,8 +101,36 @@ func New() (*Lua, error) {
 	return lua, nil
 }
 
+// NewWithLibs creates a new lua vm opening only the named standard libraries.
+// names are base, package, table, io, os, string, math, debug, bit, jit and ffi.
+func NewWithLibs(libs ...string) (*Lua, error) {
+	state := C.luaL_newstate()
+	if state == nil {
+		return nil, fmt.Errorf("lua newstate")
+	}
+	for _, lib := range libs {
+		if C.open_lib(state, cstr(lib)) != 0 {
+			C.lua_close(state)
+			return nil, fmt.Errorf("unknown library %s", lib)
+		}
+	}
+	lua := &Lua{
+		State: state,
+	}
+	return lua, nil
+}
+
+// MemoryUsage returns the bytes of memory used by the lua vm
+func (l *Lua) MemoryUsage() int {
+	return int(C.lua_gc(l.State, C.LUA_GCCOUNT, 0))*1024 + int(C.lua_gc(l.State, C.LUA_GCCOUNTB, 0))
+}
+
 // Pset sets lua variable. no panic when error occur.
 func (l *Lua) Pset(args ...interface{}) error {
+	return eachNameValue(args, l.set)
+}
+
+func eachNameValue(args []interface{}, fn func(name string, value interface{}) error) error {
 	if len(args)%2 != 0 {
 		return fmt.Errorf("number of arguments not match")
 	}
@@ -76,7 +139,7 @@ func (l *Lua) Pset(args ...interface{}) error {
 		if !ok {
 			return fmt.Errorf("name must be string, not %v", args[i])
 		}
-		err := l.set(name, args[i+1])
+		err := fn(name, args[i+1])
 		if err != nil {
 			return err
 		}
@@ -93,24 +156,35 @@ func (l *Lua) Set(args ...interface{}) {
 }
 
 func (l *Lua) set(fullname string, v interface{}) error {
+	defer C.lua_settop(l.State, 0)
+	return l.setPath(C.LUA_GLOBALSINDEX, l.rawAccess, fullname, v)
+}
+
+// setPath sets the value at the path starting from the table at root
+func (l *Lua) setPath(root C.int, raw bool, fullname string, v interface{}) error {
+	segments, err := parsePath(fullname)
+	if err != nil {
+		return err
+	}
+	last := segments[len(segments)-1]
+	if last.method {
+		return fmt.Errorf("cannot set method: %s", fullname)
+	}
+
 	// ensure name
-	errMsg := C.ensure_name(l.State, C.CString(fullname))
-	if errMsg != nil {
-		return fmt.Errorf("%s: %s", errMsg, fullname)
+	if err := l.ensureSegments(root, raw, segments, fullname); err != nil {
+		return err
 	}
+	l.pushKey(last)
 
 	// push value
-	err := l.pushGoValue(v, fullname)
+	err = l.pushGoValue(v, fullname)
 	if err != nil {
 		return err
 	}
 
 	// set
-	C.lua_rawset(l.State, -3)
-	// clear stack
-	C.lua_settop(l.State, 0)
-
-	return nil
+	return l.setIndex(raw)
 }
 
 func (l *Lua) pushGoValue(v interface{}, name string) error {
@@ -126,7 +200,7 @@ func (l *Lua) pushGoValue(v interface{}, name string) error {
 			C.lua_pushboolean(l.State, C.int(0))
 		}
 	case string:
-		C.lua_pushstring(l.State, C.CString(value))
+		l.pushString(value)
 	case int:
 		C.lua_pushnumber(l.State, C.lua_Number(C.longlong(value)))
 	case int8:
@@ -153,27 +227,14 @@ func (l *Lua) pushGoValue(v interface{}, name string) error {
 		C.lua_pushnumber(l.State, C.lua_Number(C.double(value)))
 	case unsafe.Pointer:
 		C.lua_pushlightuserdata(l.State, value)
+	case *Function:
+		return l.pushGoFunc(value, name)
 	default:
 		// not basic types, use reflect
 		switch valueType := reflect.TypeOf(v); valueType.Kind() {
 		case reflect.Func:
 			// function
-			if valueType.IsVariadic() {
-				return fmt.Errorf("variadic function is not supported, %s", name)
-			}
-			function := &_Function{
-				name:      name,
-				lua:       l,
-				fun:       v,
-				funcType:  valueType,
-				funcValue: reflect.ValueOf(v),
-				argc:      valueType.NumIn(),
-			}
-			funcsLock.Lock()
-			funcs = append(funcs, function)
-			id := len(funcs) - 1
-			funcsLock.Unlock()
-			C.push_go_func(l.State, C.int64_t(id))
+			return l.pushGoFunc(&Function{fun: v}, name)
 		case reflect.Slice:
 			value := reflect.ValueOf(v)
 			length := value.Len()
@@ -186,6 +247,29 @@ func (l *Lua) pushGoValue(v interface{}, name string) error {
 				}
 				C.lua_settable(l.State, -3)
 			}
+		case reflect.Map:
+			value := reflect.ValueOf(v)
+			C.lua_createtable(l.State, 0, C.int(value.Len()))
+			iter := value.MapRange()
+			for iter.Next() {
+				err := l.pushGoValue(iter.Key().Interface(), "")
+				if err != nil {
+					return err
+				}
+				valueName := name
+				if key := iter.Key(); key.Kind() == reflect.String {
+					// functions in module tables are named like module.key
+					if valueName != "" {
+						valueName += "."
+					}
+					valueName += key.String()
+				}
+				err = l.pushGoValue(iter.Value().Interface(), valueName)
+				if err != nil {
+					return err
+				}
+				C.lua_rawset(l.State, -3)
+			}
 		case reflect.Ptr:
 			C.lua_pushlightuserdata(l.State, unsafe.Pointer(reflect.ValueOf(v).Pointer()))
 		default:
@@ -196,9 +280,80 @@ func (l *Lua) pushGoValue(v interface{}, name string) error {
 	return nil
 }
 
+// at returns a Lua operating on state, which is l's main state or one of its coroutines
+func (l *Lua) at(state *C.lua_State) *Lua {
+	if state == l.State {
+		return l
+	}
+	view := *l
+	view.State = state
+	view.main = l.mainLua()
+	return &view
+}
+
+func (l *Lua) mainLua() *Lua {
+	if l.main != nil {
+		return l.main
+	}
+	return l
+}
+
+func (l *Lua) pushString(str string) {
+	cStr := C.CString(str)
+	defer C.free(unsafe.Pointer(cStr))
+	C.lua_pushlstring(l.State, cStr, C.size_t(len(str)))
+}
+
+func (l *Lua) pushGoFunc(f *Function, name string) error {
+	funcType := reflect.TypeOf(f.fun)
+	if funcType == nil || funcType.Kind() != reflect.Func {
+		return fmt.Errorf("not a function %v, %s", f.fun, name)
+	}
+	if funcType.IsVariadic() {
+		return fmt.Errorf("variadic function is not supported, %s", name)
+	}
+	if _, ok := f.fun.(func(*CallContext) int); ok && f.async {
+		// the context reads the lua stack, which is not accessible from other goroutines
+		return fmt.Errorf("async function cannot take *CallContext, %s", name)
+	}
+	function := &_Function{
+		name:      name,
+		lua:       l.mainLua(),
+		fun:       f.fun,
+		funcType:  funcType,
+		funcValue: reflect.ValueOf(f.fun),
+		argc:      funcType.NumIn(),
+		async:     f.async,
+		coerce:    f.coerce,
+	}
+	if funcType.NumIn() > 0 && funcType.In(0) == callInfoType {
+		if f.async {
+			// same as *CallContext, arguments are read from the suspended coroutine
+			return fmt.Errorf("async function cannot take *CallInfo, %s", name)
+		}
+		function.callInfo = true
+		function.argc--
+	}
+	if err := function.setParams(f.defaults); err != nil {
+		return err
+	}
+	funcsLock.Lock()
+	funcs = append(funcs, function)
+	id := len(funcs) - 1
+	funcsLock.Unlock()
+	C.push_go_func(l.State, C.int64_t(id))
+	return nil
+}
+
 func (l *Lua) getStackTraceback() string {
 	C.lua_getfield(l.State, C.LUA_GLOBALSINDEX, cstr("debug"))
+	if C.lua_type(l.State, -1) != C.LUA_TTABLE { // debug library not opened
+		return ""
+	}
 	C.lua_getfield(l.State, -1, cstr("traceback"))
+	if C.lua_type(l.State, -1) != C.LUA_TFUNCTION {
+		return ""
+	}
 	C.lua_call(l.State, 0, 1)
 	return C.GoString(C.lua_tolstring(l.State, -1, nil))
 }
@@ -209,99 +364,185 @@ func invokeGoFunc(state *C.lua_State) int {
 	funcsLock.RLock()
 	function := funcs[id]
 	funcsLock.RUnlock()
-	// fast paths
-	switch f := function.fun.(type) {
-	case func():
-		f()
-		return 0
+	// fast paths, async functions go through the scheduler below
+	if !function.async {
+		switch f := function.fun.(type) {
+		case func():
+			f()
+			return 0
+		case func(*CallContext) int:
+			l := function.lua.at(state)
+			ctx := &CallContext{
+				lua:      l,
+				function: function,
+				coerce:   l.coerce || function.coerce,
+			}
+			n := f(ctx)
+			if ctx.err != nil {
+				// raised by call_go_func, so it can be caught by pcall in lua
+				l.pushString(ctx.err.Error())
+				return C.GO_FUNC_ERROR
+			}
+			return n
+		}
 	}
+	// called from a coroutine, convert values on its stack
+	l := function.lua.at(state)
 	// check args
-	argc := C.lua_gettop(state)
-	if int(argc) != function.argc {
+	argc := int(C.lua_gettop(state))
+	if argc < function.minArgc || argc > function.argc {
 		// Lua.Eval will check err
 		function.lua.err = fmt.Errorf("CALL ERROR: number of arguments not match: %s\n%s",
-			function.name, function.lua.getStackTraceback())
+			function.name, l.getStackTraceback())
 		return 0
 	}
 	// prepare args
 	var args []reflect.Value
-	for i := C.int(1); i <= argc; i++ {
-		goValue, err := function.lua.toGoValue(i, function.funcType.In(int(i-1)))
+	if function.callInfo {
+		args = append(args, reflect.ValueOf(l.callInfo(function)))
+	}
+	for i := 0; i < function.argc; i++ {
+		goValue, err := l.argValue(function, i, argc)
 		if err != nil {
 			function.lua.err = fmt.Errorf("CALL ERROR: toGoValue error: %v\n%s",
-				err, function.lua.getStackTraceback())
+				err, l.getStackTraceback())
 			return 0
 		}
-		if goValue != nil {
-			args = append(args, *goValue)
-		} else {
-			args = append(args, reflect.Zero(function.funcType.In(int(i-1))))
+		args = append(args, goValue)
+	}
+	// async, suspend the coroutine until the scheduler resumes it with the results
+	if function.async {
+		thread, ok := function.lua.asyncThreads[state]
+		if !ok {
+			function.lua.err = fmt.Errorf("CALL ERROR: async function must be called in a scheduler coroutine: %s\n%s",
+				function.name, l.getStackTraceback())
+			return 0
 		}
+		thread.pending = &asyncCall{
+			function: function,
+			args:     args,
+		}
+		return -1
 	}
 	// call and returns
 	returnValues := function.funcValue.Call(args)
+	if len(returnValues) == 1 {
+		if values, ok := returnValues[0].Interface().(Yield); ok {
+			for _, v := range values {
+				l.pushGoValue(v, "")
+			}
+			// call_go_func yields with these values
+			return -len(values) - 1
+		}
+	}
 	for _, v := range returnValues {
-		function.lua.pushGoValue(v.Interface(), "")
+		l.pushGoValue(v.Interface(), "")
 	}
 	return len(returnValues)
 }
 
 // Peval evaluates a piece of lua code. no panic when error occur.
+// envs are name-value pairs, a map with string keys, a struct, or an *Env.
+// names are paths like in Set, so dotted names create nested tables.
+// struct fields are named by the lua tag, or the field name if not tagged. fields tagged "-" are skipped.
 func (l *Lua) Peval(code string, envs ...interface{}) (returns []interface{}, err error) {
+	if l.evalCache != nil {
+		chunk, err := l.evalCache.get(l, code)
+		if err != nil {
+			return nil, err
+		}
+		return chunk.Run(envs...)
+	}
 	defer C.lua_settop(l.State, 0)
 	C.push_errfunc(l.State)
 	curTop := C.lua_gettop(l.State)
 	// parse
-	cCode := C.CString(code)
-	defer C.free(unsafe.Pointer(cCode))
-	if ret := C.luaL_loadstring(l.State, cCode); ret != 0 { // load error
-		return nil, fmt.Errorf("LOAD ERROR: %s", C.GoString(C.lua_tolstring(l.State, -1, nil)))
+	if err := l.load(code, []byte(code)); err != nil {
+		return nil, err
+	}
+	return l.run(curTop, envs)
+}
+
+// load pushes the function compiled from code
+func (l *Lua) load(chunkname string, code []byte) error {
+	if l.refuseBinary && isBinaryChunk(code) {
+		return fmt.Errorf("LOAD ERROR: binary chunk refused")
+	}
+	cName := C.CString(chunkname)
+	defer C.free(unsafe.Pointer(cName))
+	var buf *C.char
+	if len(code) > 0 {
+		buf = (*C.char)(unsafe.Pointer(&code[0]))
+	}
+	if ret := C.luaL_loadbuffer(l.State, buf, C.size_t(len(code)), cName); ret != 0 { // load error
+		return fmt.Errorf("LOAD ERROR: %s", C.GoString(C.lua_tolstring(l.State, -1, nil)))
 	}
+	return nil
+}
+
+// run calls the function on top of the stack with envs. the error function is at curTop.
+func (l *Lua) run(curTop C.int, envs []interface{}) (returns []interface{}, err error) {
+	if err := l.call(curTop, envs); err != nil {
+		return nil, err
+	}
+	return l.returns(curTop)
+}
+
+// call calls the function at the top of the stack with envs, leaving the results above curTop
+func (l *Lua) call(curTop C.int, envs []interface{}) error {
 	// env
+	if len(envs) == 1 {
+		if env, ok := envs[0].(*Env); ok {
+			if env.ref == C.LUA_NOREF {
+				return fmt.Errorf("env is closed")
+			}
+			C.lua_rawgeti(l.State, C.LUA_REGISTRYINDEX, env.ref)
+			C.lua_setfenv(l.State, -2)
+			envs = nil
+		}
+	}
 	if len(envs) > 0 {
-		if len(envs)%2 != 0 {
-			return nil, fmt.Errorf("number of arguments not match")
+		pairs, err := envPairs(envs)
+		if err != nil {
+			return err
 		}
 		C.lua_createtable(l.State, 0, 0)
-		for i := 0; i < len(envs); i += 2 {
-			name, ok := envs[i].(string)
-			if !ok {
-				return nil, fmt.Errorf("name must be string, not %v", envs[i])
-			}
-			C.lua_pushstring(l.State, cstr(name))
-			err := l.pushGoValue(envs[i+1], name)
-			if err != nil {
-				return nil, err
-			}
-			C.lua_rawset(l.State, -3)
+		root := C.lua_gettop(l.State)
+		err = eachNameValue(pairs, func(name string, value interface{}) error {
+			return l.setPath(root, true, name, value)
+		})
+		if err != nil {
+			return err
 		}
 		// set env
 		C.set_eval_env(l.State)
 	}
 	// call
 	l.err = nil
-	if ret := C.lua_pcall(l.State, 0, C.LUA_MULTRET, -2); ret != 0 {
+	if ret := C.lua_pcall(l.State, 0, C.LUA_MULTRET, curTop); ret != 0 {
 		// error occured
-		return nil, fmt.Errorf("CALL ERROR: %s", C.GoString(C.lua_tolstring(l.State, -1, nil)))
-	} else if l.err != nil { // error raise by invokeGoFunc
-		return nil, l.err
-	} else {
-		// return values
-		nReturn := C.lua_gettop(l.State) - curTop
-		returns = make([]interface{}, int(nReturn))
-		for i := C.int(0); i < nReturn; i++ {
-			value, err := l.toGoValue(-1-i, interfaceType)
-			if err != nil {
-				return nil, err
-			}
-			if value != nil {
-				returns[int(nReturn-1-i)] = value.Interface()
-			} else {
-				returns[int(nReturn-1-i)] = nil
-			}
+		return fmt.Errorf("CALL ERROR: %s", C.GoString(C.lua_tolstring(l.State, -1, nil)))
+	}
+	// error raise by invokeGoFunc
+	return l.err
+}
+
+// returns converts values above curTop to go values
+func (l *Lua) returns(curTop C.int) ([]interface{}, error) {
+	nReturn := C.lua_gettop(l.State) - curTop
+	returns := make([]interface{}, int(nReturn))
+	for i := C.int(0); i < nReturn; i++ {
+		value, err := l.toGoValue(-1-i, interfaceType)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			returns[int(nReturn-1-i)] = value.Interface()
+		} else {
+			returns[int(nReturn-1-i)] = nil
 		}
 	}
-	return
+	return returns, nil
 }
 
 // Eval evaluates a piece of lua code. panic if error occur.
@@ -320,6 +561,15 @@ var boolType = reflect.TypeOf(true)
 var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
 
 func (l *Lua) toGoValue(i C.int, paramType reflect.Type) (ret *reflect.Value, err error) {
+	return l.toGoValueWith(i, paramType, l.coerce)
+}
+
+// toGoValueWith converts the value at i to paramType. numbers and strings are converted to each other if coerce is true.
+func (l *Lua) toGoValueWith(i C.int, paramType reflect.Type, coerce bool) (ret *reflect.Value, err error) {
+	if i < 0 && i > C.LUA_REGISTRYINDEX {
+		// tables are traversed by pushing keys, relative indexes would move
+		i = C.lua_gettop(l.State) + 1 + i
+	}
 	luaType := C.lua_type(l.State, i)
 	paramKind := paramType.Kind()
 	switch paramKind {
@@ -331,28 +581,45 @@ func (l *Lua) toGoValue(i C.int, paramType reflect.Type) (ret *reflect.Value, er
 		v := reflect.ValueOf(C.lua_toboolean(l.State, i) == C.int(1))
 		ret = &v
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		if luaType != C.LUA_TNUMBER {
+		n, ok := l.toNumber(i, luaType, coerce)
+		if !ok {
 			err = fmt.Errorf("not an integer")
 			return
 		}
+		if err = checkInteger(n); err != nil {
+			return
+		}
 		v := reflect.New(paramType).Elem()
-		v.SetInt(int64(C.lua_tointeger(l.State, i)))
+		if n < -(1<<63) || n >= 1<<63 || v.OverflowInt(int64(n)) {
+			err = fmt.Errorf("integer out of range: %s for %v", formatNumber(n), paramType)
+			return
+		}
+		v.SetInt(int64(n))
 		ret = &v
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		if luaType != C.LUA_TNUMBER {
+		n, ok := l.toNumber(i, luaType, coerce)
+		if !ok {
 			err = fmt.Errorf("not a unsigned")
 			return
 		}
+		if err = checkInteger(n); err != nil {
+			return
+		}
 		v := reflect.New(paramType).Elem()
-		v.SetUint(uint64(C.lua_tointeger(l.State, i)))
+		if n < 0 || n >= 1<<64 || v.OverflowUint(uint64(n)) {
+			err = fmt.Errorf("integer out of range: %s for %v", formatNumber(n), paramType)
+			return
+		}
+		v.SetUint(uint64(n))
 		ret = &v
 	case reflect.Float32, reflect.Float64:
-		if luaType != C.LUA_TNUMBER {
+		n, ok := l.toNumber(i, luaType, coerce)
+		if !ok {
 			err = fmt.Errorf("not a float")
 			return
 		}
 		v := reflect.New(paramType).Elem()
-		v.SetFloat(float64(C.lua_tonumber(l.State, i)))
+		v.SetFloat(n)
 		ret = &v
 	case reflect.Interface:
 		switch paramType {
@@ -384,12 +651,16 @@ func (l *Lua) toGoValue(i C.int, paramType reflect.Type) (ret *reflect.Value, er
 			return
 		}
 	case reflect.String:
-		if luaType != C.LUA_TSTRING {
+		v := reflect.New(paramType).Elem()
+		switch {
+		case luaType == C.LUA_TSTRING:
+			v.SetString(C.GoString(C.lua_tolstring(l.State, i, nil)))
+		case luaType == C.LUA_TNUMBER && coerce:
+			v.SetString(l.numberToString(i))
+		default:
 			err = fmt.Errorf("not a string")
 			return
 		}
-		v := reflect.New(paramType).Elem()
-		v.SetString(C.GoString(C.lua_tolstring(l.State, i, nil)))
 		ret = &v
 	case reflect.Slice:
 		switch luaType {
@@ -403,7 +674,7 @@ func (l *Lua) toGoValue(i C.int, paramType reflect.Type) (ret *reflect.Value, er
 			C.lua_pushnil(l.State)
 			elemType := paramType.Elem()
 			for C.lua_next(l.State, i) != 0 {
-				elemValue, e := l.toGoValue(-1, elemType)
+				elemValue, e := l.toGoValueWith(-1, elemType, coerce)
 				if e != nil {
 					err = e
 					return
@@ -435,13 +706,13 @@ func (l *Lua) toGoValue(i C.int, paramType reflect.Type) (ret *reflect.Value, er
 		keyType := paramType.Key()
 		elemType := paramType.Elem()
 		for C.lua_next(l.State, i) != 0 {
-			keyValue, e := l.toGoValue(-2, keyType)
+			keyValue, e := l.toGoValueWith(-2, keyType, coerce)
 			if e != nil {
 				err = e
 				return
 			}
 			// table has no nil key so keyValue will not be nil
-			elemValue, e := l.toGoValue(-1, elemType)
+			elemValue, e := l.toGoValueWith(-1, elemType, coerce)
 			if e != nil {
 				err = e
 				return
@@ -461,26 +732,49 @@ func (l *Lua) toGoValue(i C.int, paramType reflect.Type) (ret *reflect.Value, er
 	return
 }
 
-// Pcall calls a lua function. no panic
+// pushFunction pushes the lua function named fullname.
+// for method names like obj:method, the receiver is pushed after the function and nself is 1.
+func (l *Lua) pushFunction(fullname string) (nself int, err error) {
+	segments, err := parsePath(fullname)
+	if err != nil {
+		return 0, err
+	}
+	last := segments[len(segments)-1]
+	if !last.method {
+		if err := l.pushSegments(C.LUA_GLOBALSINDEX, l.rawAccess, segments, fullname); err != nil ||
+			C.lua_type(l.State, -1) != C.LUA_TFUNCTION {
+			return 0, fmt.Errorf("%s is not a function", fullname)
+		}
+		return 0, nil
+	}
+	// method
+	if err := l.pushSegments(C.LUA_GLOBALSINDEX, l.rawAccess, segments[:len(segments)-1], fullname); err != nil {
+		return 0, fmt.Errorf("%s is not a function", fullname)
+	}
+	receiverType := C.lua_type(l.State, -1)
+	if receiverType != C.LUA_TTABLE && receiverType != C.LUA_TUSERDATA {
+		return 0, fmt.Errorf("%s is not a function", fullname)
+	}
+	C.lua_pushvalue(l.State, -1)
+	l.pushKey(last)
+	// userdata receivers have no raw fields
+	if err := l.getIndex(l.rawAccess && receiverType == C.LUA_TTABLE); err != nil ||
+		C.lua_type(l.State, -1) != C.LUA_TFUNCTION {
+		return 0, fmt.Errorf("%s is not a function", fullname)
+	}
+	C.lua_insert(l.State, -2) // function, receiver
+	return 1, nil
+}
+
+// Pcall calls a lua function. for names like obj:method, obj is passed as the first argument. no panic
 func (l *Lua) Pcall(fullname string, args ...interface{}) (returns []interface{}, err error) {
+	defer C.lua_settop(l.State, 0)
 	C.push_errfunc(l.State)
 	curTop := C.lua_gettop(l.State)
 	// get function
-	path := strings.Split(fullname, ".")
-	for i, name := range path {
-		if i == 0 {
-			C.lua_getfield(l.State, C.LUA_GLOBALSINDEX, cstr(name))
-		} else {
-			if C.lua_type(l.State, -1) != C.LUA_TTABLE {
-				return nil, fmt.Errorf("%s is not a function", fullname)
-			}
-			C.lua_pushstring(l.State, cstr(name))
-			C.lua_gettable(l.State, -2)
-			C.lua_remove(l.State, -2) // remove table
-		}
-	}
-	if C.lua_type(l.State, -1) != C.LUA_TFUNCTION {
-		return nil, fmt.Errorf("%s is not a function", fullname)
+	nself, err := l.pushFunction(fullname)
+	if err != nil {
+		return nil, err
 	}
 	// args
 	for _, arg := range args {
@@ -488,24 +782,15 @@ func (l *Lua) Pcall(fullname string, args ...interface{}) (returns []interface{}
 	}
 	// call
 	l.err = nil
-	if ret := C.lua_pcall(l.State, C.int(len(args)), C.LUA_MULTRET, C.int(-(len(args))-2)); ret != 0 {
+	if ret := C.lua_pcall(l.State, C.int(nself+len(args)), C.LUA_MULTRET, curTop); ret != 0 {
 		// error occured
 		return nil, fmt.Errorf("CALL ERROR: %s", C.GoString(C.lua_tolstring(l.State, -1, nil)))
 	} else if l.err != nil { // error raise by invokeGoFunc
 		return nil, l.err
 	} else {
 		// return values
-		nReturn := C.lua_gettop(l.State) - curTop
-		returns = make([]interface{}, int(nReturn))
-		for i := C.int(0); i < nReturn; i++ {
-			value, err := l.toGoValue(-1-i, interfaceType)
-			if err != nil {
-				return nil, err
-			}
-			returns[int(nReturn-1-i)] = value.Interface()
-		}
+		return l.returns(curTop)
 	}
-	return
 }
 
 // Call calls a lua function. panic if error
@@ -519,6 +804,7 @@ func (l *Lua) Call(fullname string, args ...interface{}) []interface{} {
 
 // Close close the lua vm
 func (l *Lua) Close() {
+	forgetHook(l.State)
 	C.lua_close(l.State)
 }
 
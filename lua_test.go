@@ -757,3 +757,104 @@ func TestUnicode(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestCallMethod(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.Eval(`
+	Account = {}
+	Account.__index = Account
+	function Account:deposit(n)
+		self.balance = self.balance + n
+		return self.balance
+	end
+	accounts = {main = setmetatable({balance = 0}, Account)}
+
+	counter = newproxy(true)
+	local n = 0
+	getmetatable(counter).__index = {
+		add = function(self, k)
+			if self ~= counter then error('bad self') end
+			n = n + k
+			return n
+		end,
+	}
+	`)
+
+	// table receiver
+	ret, err := l.Pcall("accounts.main:deposit", 42)
+	if err != nil || ret[0].(float64) != 42 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	if l.Call(`accounts["main"]:deposit`, 1)[0].(float64) != 43 {
+		t.Fatalf("bad return")
+	}
+	// without self
+	_, err = l.Pcall("accounts.main.deposit", 42)
+	if err == nil {
+		t.Fatalf("allowing call without self")
+	}
+
+	// userdata receiver
+	ret, err = l.Pcall("counter:add", 2)
+	if err != nil || ret[0].(float64) != 2 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+
+	// coroutine
+	thread, err := l.NewThread("counter:add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer thread.Close()
+	ret, _, err = thread.Resume(3)
+	if err != nil || ret[0].(float64) != 5 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+
+	// bad methods
+	for _, name := range []string{
+		"accounts.main:none",
+		"accounts.none:deposit",
+		"Account.__index:none",
+		"counter:none",
+		"newproxy:foo",
+	} {
+		_, err = l.Pcall(name)
+		if err == nil || !strings.Contains(err.Error(), "is not a function") {
+			t.Fatalf("allowing bad method %s or error %v", name, err)
+		}
+	}
+	l.Eval(`bare = newproxy(false)`)
+	_, err = l.Pcall("bare:foo")
+	if err == nil || !strings.Contains(err.Error(), "is not a function") {
+		t.Fatalf("allowing indexing userdata without metatable or error %v", err)
+	}
+}
+
+func TestNewWithLibs(t *testing.T) {
+	l, err := NewWithLibs("base", "string")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ret, err := l.Peval(`return type(string), type(io), type(debug)`)
+	if err != nil || ret[0] != "table" || ret[1] != "nil" || ret[2] != "nil" {
+		t.Fatalf("bad libs %v %v", ret, err)
+	}
+	// no traceback without debug library
+	if _, err := l.Peval(`error('foo')`); err == nil || !strings.Contains(err.Error(), "foo") {
+		t.Fatalf("should fail %v", err)
+	}
+	if l.MemoryUsage() <= 0 {
+		t.Fatalf("bad memory usage")
+	}
+
+	if _, err := NewWithLibs("foo"); err == nil {
+		t.Fatalf("should fail")
+	}
+}
@@ -0,0 +1,91 @@
+package lua
+
+/*
+#include <lua.h>
+*/
+import "C"
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// RegisterModule makes require(name) return the table built by loader.
+// loader is called by the first require.
+func (l *Lua) RegisterModule(name string, loader func(*Lua) map[string]interface{}) error {
+	defer C.lua_settop(l.State, 0)
+	C.lua_getfield(l.State, C.LUA_GLOBALSINDEX, cstr("package"))
+	if C.lua_type(l.State, -1) != C.LUA_TTABLE {
+		return fmt.Errorf("package library not loaded")
+	}
+	C.lua_getfield(l.State, -1, cstr("preload"))
+	if C.lua_type(l.State, -1) != C.LUA_TTABLE {
+		return fmt.Errorf("package.preload is not a table")
+	}
+	l.pushString(name)
+	err := l.pushGoValue(func(c *CallContext) int {
+		// pushed with the module name, so functions in it are named like module.key
+		if err := c.lua.pushGoValue(loader(l), name); err != nil {
+			return c.Error(err)
+		}
+		return 1
+	}, name)
+	if err != nil {
+		return err
+	}
+	C.lua_rawset(l.State, -3)
+	return nil
+}
+
+// AddModuleFS makes require search modules in fsys after the default searchers.
+// module a.b is loaded from a/b.lua or a/b/init.lua
+func (l *Lua) AddModuleFS(fsys fs.FS) error {
+	return l.addSearcher(func(name string) (interface{}, string) {
+		modulePath, code, err := findModule(fsys, name)
+		if err != nil {
+			return nil, err.Error()
+		}
+		if l.refuseBinary && isBinaryChunk([]byte(code)) {
+			return nil, fmt.Sprintf("\n\tbinary chunk refused '%s'", modulePath)
+		}
+		return code, modulePath
+	})
+}
+
+// addSearcher appends a searcher to package.loaders.
+// find returns the module code and path, or nil and the message of not found.
+func (l *Lua) addSearcher(find func(name string) (interface{}, string)) error {
+	_, err := l.Peval(`
+	local find = find
+	local loaders = package.loaders
+	loaders[#loaders + 1] = function(name)
+		local code, path = find(name)
+		if code == nil then
+			return path
+		end
+		local loader, err = loadstring(code, '@' .. path)
+		if loader == nil then
+			error("error loading module '" .. name .. "' from file '" .. path .. "':\n\t" .. err, 0)
+		end
+		return loader
+	end
+	`, "find", find)
+	return err
+}
+
+func findModule(fsys fs.FS, name string) (modulePath string, code string, err error) {
+	base := strings.Replace(name, ".", "/", -1)
+	var msg string
+	for _, modulePath := range []string{
+		base + ".lua",
+		path.Join(base, "init.lua"),
+	} {
+		content, err := fs.ReadFile(fsys, modulePath)
+		if err == nil {
+			return modulePath, string(content), nil
+		}
+		msg += fmt.Sprintf("\n\tno file '%s'", modulePath)
+	}
+	return "", "", fmt.Errorf("%s", msg)
+}
@@ -0,0 +1,100 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRegisterModule(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	loads := 0
+	err = l.RegisterModule("go.math", func(l *Lua) map[string]interface{} {
+		loads++
+		return map[string]interface{}{
+			"pi": 3,
+			"add": func(a, b int) int {
+				return a + b
+			},
+			"consts": map[string]int{
+				"answer": 42,
+			},
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := l.Peval(`
+	local m = require('go.math')
+	assert(require('go.math') == m)
+	return m.add(m.pi, 1), m.consts.answer
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret[0].(float64) != 4 || ret[1].(float64) != 42 {
+		t.Fatalf("bad return %v", ret)
+	}
+	if loads != 1 {
+		t.Fatalf("module loaded %d times", loads)
+	}
+	// functions are named after the module
+	_, err = l.Peval(`require('go.math').add(1)`)
+	if err == nil || !strings.Contains(err.Error(), "number of arguments not match: go.math.add") {
+		t.Fatalf("bad name or error %v", err)
+	}
+
+	// no package library
+	l.Eval(`package = nil`)
+	err = l.RegisterModule("foo", func(l *Lua) map[string]interface{} {
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("allowing missing package library")
+	}
+}
+
+func TestModuleFS(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	err = l.AddModuleFS(fstest.MapFS{
+		"foo/bar.lua": &fstest.MapFile{
+			Data: []byte(`return {name = ...}`),
+		},
+		"foo/init.lua": &fstest.MapFile{
+			Data: []byte(`return {bar = require('foo.bar')}`),
+		},
+		"broken.lua": &fstest.MapFile{
+			Data: []byte("\nfoo bar"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := l.Peval(`return require('foo').bar.name`)
+	if err != nil || ret[0].(string) != "foo.bar" {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+
+	// not found
+	_, err = l.Peval(`require('none.such')`)
+	if err == nil || !strings.Contains(err.Error(), "no file 'none/such.lua'") ||
+		!strings.Contains(err.Error(), "no file 'none/such/init.lua'") {
+		t.Fatalf("bad error %v", err)
+	}
+
+	// compile error
+	_, err = l.Peval(`require('broken')`)
+	if err == nil || !strings.Contains(err.Error(), "broken.lua:2:") {
+		t.Fatalf("bad error %v", err)
+	}
+}
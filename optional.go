@@ -0,0 +1,137 @@
+package lua
+
+/*
+#include <lua.h>
+*/
+import "C"
+import (
+	"fmt"
+	"reflect"
+)
+
+// Optional is a parameter type of go functions for arguments that can be omitted or nil.
+// trailing Optional and pointer parameters can be omitted by lua callers.
+type Optional[T any] struct {
+	Value T
+	Valid bool // false if the argument is omitted or nil
+}
+
+// Or returns the value, or def if the argument is omitted or nil
+func (o Optional[T]) Or(def T) T {
+	if !o.Valid {
+		return def
+	}
+	return o.Value
+}
+
+func (o Optional[T]) isOptional() {}
+
+type optionalParam interface {
+	isOptional()
+}
+
+var optionalParamType = reflect.TypeOf((*optionalParam)(nil)).Elem()
+
+// isOptional reports whether t is an Optional. pointers to Optional also implement optionalParam
+func isOptional(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t.Implements(optionalParamType)
+}
+
+// Defaults wraps a go function with default values of its last parameters.
+// omitted or nil arguments of these parameters get the defaults.
+func Defaults(fn interface{}, defaults ...interface{}) *Function {
+	f := asFunction(fn)
+	f.defaults = defaults
+	return f
+}
+
+// setParams sets argument number limits and defaults of the lua parameters
+func (f *_Function) setParams(defaults []interface{}) error {
+	if len(defaults) > f.argc {
+		return fmt.Errorf("too many defaults, %s", f.name)
+	}
+	offset := f.funcType.NumIn() - f.argc // *CallInfo
+	f.defaults = make([]reflect.Value, f.argc)
+	for i, def := range defaults {
+		param := f.argc - len(defaults) + i
+		value, err := defaultValue(def, f.funcType.In(offset+param))
+		if err != nil {
+			return fmt.Errorf("bad default of parameter %d, %s: %v", param+1, f.name, err)
+		}
+		f.defaults[param] = value
+	}
+	f.minArgc = f.argc
+	for f.minArgc > 0 {
+		paramType := f.funcType.In(offset + f.minArgc - 1)
+		if !f.defaults[f.minArgc-1].IsValid() &&
+			paramType.Kind() != reflect.Ptr &&
+			!isOptional(paramType) {
+			break
+		}
+		f.minArgc--
+	}
+	return nil
+}
+
+func defaultValue(def interface{}, paramType reflect.Type) (reflect.Value, error) {
+	if def == nil {
+		return reflect.Zero(paramType), nil
+	}
+	if isOptional(paramType) {
+		value, err := defaultValue(def, paramType.Field(0).Type)
+		if err != nil {
+			return value, err
+		}
+		optional := reflect.New(paramType).Elem()
+		optional.Field(0).Set(value)
+		optional.Field(1).SetBool(true)
+		return optional, nil
+	}
+	value := reflect.ValueOf(def)
+	switch {
+	case value.Type().AssignableTo(paramType):
+		return value, nil
+	case isNumberKind(value.Kind()) && isNumberKind(paramType.Kind()):
+		return value.Convert(paramType), nil
+	}
+	return value, fmt.Errorf("%T is not assignable to %v", def, paramType)
+}
+
+func isNumberKind(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Float64
+}
+
+// argValue converts the ith lua argument, starting from 0, of a call with argc arguments
+func (l *Lua) argValue(function *_Function, i int, argc int) (reflect.Value, error) {
+	paramType := function.funcType.In(function.funcType.NumIn() - function.argc + i)
+	coerce := l.coerce || function.coerce
+	missing := i >= argc || C.lua_type(l.State, C.int(i+1)) == C.LUA_TNIL
+	if missing {
+		if def := function.defaults[i]; def.IsValid() {
+			return def, nil
+		}
+		if i >= argc || paramType.Kind() == reflect.Ptr || isOptional(paramType) {
+			return reflect.Zero(paramType), nil
+		}
+	}
+	if isOptional(paramType) {
+		optional := reflect.New(paramType).Elem()
+		value, err := l.toGoValueWith(C.int(i+1), paramType.Field(0).Type, coerce)
+		if err != nil {
+			return optional, err
+		}
+		if value != nil {
+			optional.Field(0).Set(*value)
+		}
+		optional.Field(1).SetBool(true)
+		return optional, nil
+	}
+	value, err := l.toGoValueWith(C.int(i+1), paramType, coerce)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if value == nil {
+		return reflect.Zero(paramType), nil
+	}
+	return *value, nil
+}
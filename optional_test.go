@@ -0,0 +1,96 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestOptional(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Optional
+	l.Set("log", func(msg string, level Optional[string], times Optional[int]) string {
+		return strings.Repeat(level.Or("info")+": "+msg+";", times.Or(1))
+	})
+	for code, expected := range map[string]string{
+		`return log('foo')`:              "info: foo;",
+		`return log('foo', 'warn')`:      "warn: foo;",
+		`return log('foo', nil, 2)`:      "info: foo;info: foo;",
+		`return log('foo', 'warn', nil)`: "warn: foo;",
+	} {
+		ret, err := l.Peval(code)
+		if err != nil || ret[0] != expected {
+			t.Fatalf("%s: bad return %v %v", code, ret, err)
+		}
+	}
+	if _, err := l.Peval(`log()`); err == nil || !strings.Contains(err.Error(), "number of arguments not match") {
+		t.Fatalf("should fail %v", err)
+	}
+	if _, err := l.Peval(`log('foo', 'warn', 1, 2)`); err == nil {
+		t.Fatalf("should fail")
+	}
+	if _, err := l.Peval(`log('foo', 42)`); err == nil || !strings.Contains(err.Error(), "not a string") {
+		t.Fatalf("should fail %v", err)
+	}
+
+	// pointer
+	i := 42
+	l.Set("ip", unsafe.Pointer(&i))
+	l.Set("deref", func(n int, p *int) int {
+		if p == nil {
+			return n
+		}
+		return *p
+	})
+	ret, err := l.Peval(`return deref(1), deref(1, nil), deref(1, ip)`)
+	if err != nil || ret[0] != 1.0 || ret[1] != 1.0 || ret[2] != 42.0 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+
+	// defaults
+	l.Set("greet", Defaults(func(c *CallInfo, name string, greeting string, times float64) string {
+		return strings.Repeat(greeting+", "+name+";", int(times))
+	}, "hello", 1))
+	ret, err = l.Peval(`return greet('foo'), greet('foo', 'hi'), greet('foo', nil, 2)`)
+	if err != nil || ret[0] != "hello, foo;" || ret[1] != "hi, foo;" || ret[2] != "hello, foo;hello, foo;" {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	if _, err := l.Peval(`greet()`); err == nil {
+		t.Fatalf("should fail")
+	}
+	l.Set("level", Defaults(func(level Optional[string]) string {
+		return level.Value
+	}, "info"))
+	ret, err = l.Peval(`return level(), level('warn')`)
+	if err != nil || ret[0] != "info" || ret[1] != "warn" {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+
+	// pointers to Optional are plain pointer parameters
+	l.Set("popt", func(o *Optional[string]) bool {
+		return o == nil
+	})
+	ret, err = l.Peval(`return popt(), popt(nil)`)
+	if err != nil || ret[0] != true || ret[1] != true {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+	if _, err := l.Peval(`popt('foo')`); err == nil || !strings.Contains(err.Error(), "not a pointer") {
+		t.Fatalf("allowing bad argument or error %v", err)
+	}
+	if err := l.Pset("f", Defaults(func(*Optional[string]) {}, "foo")); err == nil {
+		t.Fatalf("should fail")
+	}
+
+	// bad defaults
+	if err := l.Pset("f", Defaults(func(string) {}, "a", "b")); err == nil {
+		t.Fatalf("should fail")
+	}
+	if err := l.Pset("f", Defaults(func(string) {}, 1)); err == nil {
+		t.Fatalf("should fail")
+	}
+}
@@ -0,0 +1,231 @@
+package lua
+
+/*
+#include <lua.h>
+
+void push_index_func(lua_State*);
+void push_newindex_func(lua_State*);
+*/
+import "C"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pathSegment is a key in a path like foo.bar["baz"][3]:method
+type pathSegment struct {
+	key    interface{} // string or int
+	method bool        // after a colon
+}
+
+// parsePath parses names used by Set, Get and Call.
+// segments are separated by dots, bracketed string keys like ["on-click"] and integer indexes like [3] are supported.
+// the last segment can be a method name after a colon, like obj:method
+func parsePath(fullname string) (segments []pathSegment, err error) {
+	bad := func(pos int, format string, args ...interface{}) ([]pathSegment, error) {
+		return nil, fmt.Errorf("invalid path %q at %d: %s", fullname, pos, fmt.Sprintf(format, args...))
+	}
+	name := func(pos int) (string, int) {
+		end := pos
+		for end < len(fullname) && !strings.ContainsRune(".[]:", rune(fullname[end])) {
+			end++
+		}
+		return fullname[pos:end], end
+	}
+	pos := 0
+	for pos < len(fullname) {
+		c := fullname[pos]
+		switch {
+		case len(segments) > 0 && segments[len(segments)-1].method:
+			return bad(pos, "method must be the last segment")
+		case c == '[':
+			pos++
+			if pos >= len(fullname) {
+				return bad(pos, "unclosed bracket")
+			}
+			if quote := fullname[pos]; quote == '"' || quote == '\'' {
+				// string key
+				var key []byte
+				pos++
+				for {
+					if pos >= len(fullname) {
+						return bad(pos, "unclosed string")
+					}
+					c := fullname[pos]
+					if c == quote {
+						pos++
+						break
+					}
+					if c == '\\' {
+						pos++
+						if pos >= len(fullname) {
+							return bad(pos, "unclosed string")
+						}
+						c = fullname[pos]
+					}
+					key = append(key, c)
+					pos++
+				}
+				segments = append(segments, pathSegment{key: string(key)})
+			} else {
+				// integer index
+				end := strings.IndexByte(fullname[pos:], ']')
+				if end < 0 {
+					return bad(pos, "unclosed bracket")
+				}
+				index, err := strconv.Atoi(fullname[pos : pos+end])
+				if err != nil {
+					return bad(pos, "bad index %q", fullname[pos:pos+end])
+				}
+				pos += end
+				segments = append(segments, pathSegment{key: index})
+			}
+			if pos >= len(fullname) || fullname[pos] != ']' {
+				return bad(pos, "expecting ]")
+			}
+			pos++
+		case c == '.' || c == ':':
+			if len(segments) == 0 {
+				return bad(pos, "empty name")
+			}
+			key, end := name(pos + 1)
+			if key == "" {
+				return bad(pos+1, "empty name")
+			}
+			segments = append(segments, pathSegment{key: key, method: c == ':'})
+			pos = end
+		default:
+			if len(segments) > 0 {
+				return bad(pos, "unexpected %q", c)
+			}
+			key, end := name(pos)
+			if key == "" {
+				return bad(pos, "unexpected %q", c)
+			}
+			segments = append(segments, pathSegment{key: key})
+			pos = end
+		}
+	}
+	if len(segments) == 0 {
+		return bad(0, "empty name")
+	}
+	return segments, nil
+}
+
+func (l *Lua) pushKey(segment pathSegment) {
+	switch key := segment.key.(type) {
+	case string:
+		l.pushString(key)
+	case int:
+		C.lua_pushnumber(l.State, C.lua_Number(key))
+	}
+}
+
+// AccessMode chooses how names are looked up by Set, Get, Call and the others
+type AccessMode int
+
+// access modes
+const (
+	// MetaAccess respects __index and __newindex metamethods, like indexing in lua code
+	MetaAccess AccessMode = iota
+	// RawAccess ignores metatables. namespaces must be tables.
+	// methods of userdata receivers are still looked up with metamethods.
+	RawAccess
+)
+
+// SetAccessMode sets how names are looked up. default is MetaAccess.
+func (l *Lua) SetAccessMode(mode AccessMode) {
+	l.rawAccess = mode == RawAccess
+}
+
+// isNamespace reports whether the value at -1 can be indexed
+func (l *Lua) isNamespace(raw bool) bool {
+	switch C.lua_type(l.State, -1) {
+	case C.LUA_TTABLE:
+		return true
+	case C.LUA_TUSERDATA:
+		return !raw
+	}
+	return false
+}
+
+// getIndex replaces the value at -2 and the key at -1 with value[key]
+func (l *Lua) getIndex(raw bool) error {
+	if raw {
+		C.lua_rawget(l.State, -2)
+		C.lua_remove(l.State, -2)
+		return nil
+	}
+	// metamethods may raise errors
+	C.push_index_func(l.State)
+	C.lua_insert(l.State, -3)
+	if C.lua_pcall(l.State, 2, 1, 0) != 0 {
+		msg := C.GoString(C.lua_tolstring(l.State, -1, nil))
+		C.lua_settop(l.State, -2)
+		return fmt.Errorf("%s", msg)
+	}
+	return nil
+}
+
+// setIndex sets value[key] = v for the value, key and v at -3, -2 and -1, and pops them
+func (l *Lua) setIndex(raw bool) error {
+	if raw {
+		C.lua_rawset(l.State, -3)
+		C.lua_settop(l.State, -2)
+		return nil
+	}
+	C.push_newindex_func(l.State)
+	C.lua_insert(l.State, -4)
+	if C.lua_pcall(l.State, 3, 0, 0) != 0 {
+		msg := C.GoString(C.lua_tolstring(l.State, -1, nil))
+		C.lua_settop(l.State, -2)
+		return fmt.Errorf("%s", msg)
+	}
+	return nil
+}
+
+// pushSegments pushes the value at the path starting from the table at root. pushes nil if a namespace not exists.
+func (l *Lua) pushSegments(root C.int, raw bool, segments []pathSegment, fullname string) error {
+	C.lua_pushvalue(l.State, root)
+	for _, segment := range segments {
+		if C.lua_type(l.State, -1) == C.LUA_TNIL {
+			continue
+		}
+		if !l.isNamespace(raw) {
+			return fmt.Errorf("invalid namespace: %s", fullname)
+		}
+		l.pushKey(segment)
+		if err := l.getIndex(raw); err != nil {
+			return fmt.Errorf("%v: %s", err, fullname)
+		}
+	}
+	return nil
+}
+
+// ensureSegments pushes the namespace containing the last segment starting from the table at root,
+// creating missing ones as tables
+func (l *Lua) ensureSegments(root C.int, raw bool, segments []pathSegment, fullname string) error {
+	C.lua_pushvalue(l.State, root)
+	for _, segment := range segments[:len(segments)-1] {
+		C.lua_pushvalue(l.State, -1)
+		l.pushKey(segment)
+		if err := l.getIndex(raw); err != nil {
+			return fmt.Errorf("%v: %s", err, fullname)
+		}
+		if C.lua_type(l.State, -1) == C.LUA_TNIL { // not exists, create new
+			C.lua_settop(l.State, -2)
+			C.lua_createtable(l.State, 0, 0)
+			C.lua_pushvalue(l.State, -2)
+			l.pushKey(segment)
+			C.lua_pushvalue(l.State, -3)
+			if err := l.setIndex(raw); err != nil {
+				return fmt.Errorf("%v: %s", err, fullname)
+			}
+		} else if !l.isNamespace(raw) {
+			return fmt.Errorf("invalid namespace: %s", fullname)
+		}
+		C.lua_remove(l.State, -2) // remove parent
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package lua
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	cases := []struct {
+		path     string
+		segments []pathSegment
+	}{
+		{"foo", []pathSegment{{key: "foo"}}},
+		{"foo.bar", []pathSegment{{key: "foo"}, {key: "bar"}}},
+		{`handlers["on-click"]`, []pathSegment{{key: "handlers"}, {key: "on-click"}}},
+		{`a['b.c']["d\"e"]`, []pathSegment{{key: "a"}, {key: "b.c"}, {key: `d"e`}}},
+		{"routes[3].handler", []pathSegment{{key: "routes"}, {key: 3}, {key: "handler"}}},
+		{"obj:method", []pathSegment{{key: "obj"}, {key: "method", method: true}}},
+		{"a[1][-2]:m", []pathSegment{{key: "a"}, {key: 1}, {key: -2}, {key: "m", method: true}}},
+	}
+	for _, c := range cases {
+		segments, err := parsePath(c.path)
+		if err != nil {
+			t.Fatalf("%s: %v", c.path, err)
+		}
+		if !reflect.DeepEqual(segments, c.segments) {
+			t.Fatalf("%s: bad segments %v", c.path, segments)
+		}
+	}
+
+	for _, path := range []string{
+		"",
+		".foo",
+		"foo.",
+		"foo..bar",
+		"foo[",
+		"foo[3",
+		"foo[x]",
+		`foo["bar`,
+		`foo["bar"`,
+		`foo["bar"]baz`,
+		"obj:method.foo",
+		"obj:",
+	} {
+		_, err := parsePath(path)
+		if err == nil || !strings.Contains(err.Error(), "invalid path") {
+			t.Fatalf("allowing bad path %q or error %v", path, err)
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.Set(`handlers["on-click"]`, func() int {
+		return 42
+	})
+	l.Set("routes[3].handler", "index")
+	_, err = l.Peval(`
+	if handlers['on-click']() ~= 42 then error('bad handler') end
+	if routes[3].handler ~= 'index' then error('bad route') end
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Get("routes[3].handler").(string) != "index" || !l.Has("routes[3]") || l.Has(`routes["3"]`) {
+		t.Fatalf("bad get")
+	}
+	ret, err := l.Pcall(`handlers["on-click"]`)
+	if err != nil || ret[0].(float64) != 42 {
+		t.Fatalf("bad call %v %v", ret, err)
+	}
+	if err := l.Delete("routes[3].handler"); err != nil || l.Has("routes[3].handler") {
+		t.Fatalf("not deleted %v", err)
+	}
+
+	// errors
+	err = l.Pset("obj:method", 1)
+	if err == nil || !strings.Contains(err.Error(), "cannot set method") {
+		t.Fatalf("allowing setting method or error %v", err)
+	}
+	err = l.Pset("foo[", 1)
+	if err == nil || !strings.Contains(err.Error(), "invalid path") {
+		t.Fatalf("allowing bad path or error %v", err)
+	}
+	_, err = l.Pget("foo[")
+	if err == nil || !strings.Contains(err.Error(), "invalid path") {
+		t.Fatalf("allowing bad path or error %v", err)
+	}
+	_, err = l.Pcall("foo[")
+	if err == nil || !strings.Contains(err.Error(), "invalid path") {
+		t.Fatalf("allowing bad path or error %v", err)
+	}
+	err = l.Delete("foo[")
+	if err == nil || !strings.Contains(err.Error(), "invalid path") {
+		t.Fatalf("allowing bad path or error %v", err)
+	}
+}
+
+func TestAccessMode(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.Eval(`
+	store = {fn = function() return 'proxied' end}
+	ns = setmetatable({}, {
+		__index = store,
+		__newindex = function(t, k, v) rawset(store, k, v) end,
+	})
+	locked = setmetatable({}, {
+		__index = function() error('no access') end,
+		__newindex = function() error('read only') end,
+	})
+	ud = newproxy(true)
+	getmetatable(ud).__index = {v = 42}
+	`)
+
+	// metamethods
+	l.Set("ns.x", 1)
+	if l.Get("ns.x").(float64) != 1 || l.Get("store.x").(float64) != 1 || !l.Has("ns.fn") {
+		t.Fatalf("__index or __newindex not respected")
+	}
+	l.Set("ns.sub.y", 2)
+	if l.Get("store.sub.y").(float64) != 2 {
+		t.Fatalf("namespace not created through __newindex")
+	}
+	if ret, err := l.Pcall("ns.fn"); err != nil || ret[0].(string) != "proxied" {
+		t.Fatalf("bad call %v %v", ret, err)
+	}
+	if err := l.Delete("ns.x"); err != nil || l.Has("store.x") {
+		t.Fatalf("not deleted through __newindex %v", err)
+	}
+	if l.Get("ud.v").(float64) != 42 {
+		t.Fatalf("userdata namespace not supported")
+	}
+	_, err = l.Pget("locked.foo")
+	if err == nil || !strings.Contains(err.Error(), "no access") {
+		t.Fatalf("bad error %v", err)
+	}
+	err = l.Pset("locked.foo.bar", 1)
+	if err == nil || !strings.Contains(err.Error(), "no access") {
+		t.Fatalf("bad error %v", err)
+	}
+	err = l.Pset("locked.foo", 1)
+	if err == nil || !strings.Contains(err.Error(), "read only") {
+		t.Fatalf("bad error %v", err)
+	}
+
+	// raw
+	l.SetAccessMode(RawAccess)
+	if err := l.Pset("locked.foo", 1); err != nil {
+		t.Fatal(err)
+	}
+	l.Set("ns.z", 3)
+	if l.Has("store.z") || l.Get("ns.z").(float64) != 3 {
+		t.Fatalf("__newindex not ignored")
+	}
+	if l.Has("ns.fn") || l.Has("ns.sub") {
+		t.Fatalf("__index not ignored")
+	}
+	if _, err := l.Pcall("ns.fn"); err == nil {
+		t.Fatalf("__index not ignored")
+	}
+	if l.Get("locked.foo").(float64) != 1 {
+		t.Fatalf("bad raw get")
+	}
+	_, err = l.Pget("ud.v")
+	if err == nil || !strings.Contains(err.Error(), "invalid namespace") {
+		t.Fatalf("allowing userdata namespace or error %v", err)
+	}
+}
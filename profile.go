@@ -0,0 +1,318 @@
+package lua
+
+/*
+#include <lua.h>
+#include <stdint.h>
+
+int64_t go_func_id(lua_State*, int);
+*/
+import "C"
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Profiler samples lua stacks every some vm instructions
+type Profiler struct {
+	lua      *Lua
+	interval int
+	start    time.Time
+	duration time.Duration
+	hook     *hookEntry
+
+	lock      sync.Mutex
+	samples   map[string]*profileSample // by location ids
+	locations map[profileLocation]uint64
+	functions map[profileFunction]uint64
+}
+
+type profileSample struct {
+	locations []uint64 // leaf first
+	leaf      string   // name of the running function
+	count     int64
+}
+
+type profileFunction struct {
+	name      string
+	filename  string
+	startLine int
+}
+
+type profileLocation struct {
+	function profileFunction
+	line     int
+}
+
+// StartProfile samples the running stack every interval vm instructions until Stop is called.
+// go functions called from lua are included as frames with their go names when they call lua code,
+// time spent in go code is not sampled.
+// it runs together with hooks of SetHook and SetCoverage. jit compiled code is not sampled.
+func (l *Lua) StartProfile(interval int) (*Profiler, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid interval %d", interval)
+	}
+	p := &Profiler{
+		lua:       l,
+		interval:  interval,
+		start:     time.Now(),
+		samples:   make(map[string]*profileSample),
+		locations: make(map[profileLocation]uint64),
+		functions: make(map[profileFunction]uint64),
+	}
+	p.hook = l.addHook(HookCount, interval, p.sample)
+	return p, nil
+}
+
+// Stop stops sampling
+func (p *Profiler) Stop() {
+	p.lua.removeHook(p.hook)
+	p.lock.Lock()
+	p.duration = time.Since(p.start)
+	p.lock.Unlock()
+}
+
+func (p *Profiler) sample(ev HookEvent) {
+	state := ev.call.state
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var ids []uint64
+	var leaf string
+	var ar C.lua_Debug
+	for level := C.int(0); C.lua_getstack(state, level, &ar) != 0; level++ {
+		if C.lua_getinfo(state, cstr("nSlf"), &ar) == 0 {
+			continue
+		}
+		goID := C.go_func_id(state, -1)
+		C.lua_settop(state, -2)
+		var loc profileLocation
+		switch {
+		case goID >= 0:
+			funcsLock.RLock()
+			function := funcs[goID]
+			funcsLock.RUnlock()
+			loc.function.name = function.name
+			if fn := runtime.FuncForPC(function.funcValue.Pointer()); fn != nil {
+				loc.function.name = fn.Name()
+				loc.function.filename, loc.line = fn.FileLine(fn.Entry())
+				loc.function.startLine = loc.line
+			}
+		case C.GoString(ar.what) == "C":
+			loc.function.name = "?"
+			if ar.name != nil {
+				loc.function.name = C.GoString(ar.name)
+			}
+			loc.function.filename = "[C]"
+		default:
+			shortSource := C.GoString(&ar.short_src[0])
+			switch {
+			case ar.name != nil:
+				loc.function.name = C.GoString(ar.name)
+			case C.GoString(ar.what) == "main":
+				loc.function.name = "main chunk"
+			default:
+				loc.function.name = fmt.Sprintf("function <%s:%d>", shortSource, ar.linedefined)
+			}
+			loc.function.filename = shortSource
+			loc.function.startLine = int(ar.linedefined)
+			loc.line = int(ar.currentline)
+		}
+		id, ok := p.locations[loc]
+		if !ok {
+			id = uint64(len(p.locations) + 1)
+			p.locations[loc] = id
+		}
+		if _, ok := p.functions[loc.function]; !ok {
+			p.functions[loc.function] = uint64(len(p.functions) + 1)
+		}
+		if len(ids) == 0 {
+			leaf = loc.function.name
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return
+	}
+	key := fmt.Sprint(ids)
+	sample, ok := p.samples[key]
+	if !ok {
+		sample = &profileSample{
+			locations: ids,
+			leaf:      leaf,
+		}
+		p.samples[key] = sample
+	}
+	sample.count++
+}
+
+// Top returns names of the functions running when sampled, most sampled first, and their sample counts
+func (p *Profiler) Top() (names []string, counts []int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	byName := make(map[string]int64)
+	for _, sample := range p.samples {
+		byName[sample.leaf] += sample.count
+	}
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := names[i], names[j]
+		if byName[a] != byName[b] {
+			return byName[a] > byName[b]
+		}
+		return a < b
+	})
+	for _, name := range names {
+		counts = append(counts, byName[name])
+	}
+	return
+}
+
+// WriteTo writes the profile in the gzipped protobuf format read by go tool pprof
+func (p *Profiler) WriteTo(w io.Writer) (int64, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	strs := &stringTable{
+		indexes: map[string]int64{"": 0},
+		strings: []string{""},
+	}
+	var b protoBuffer
+	valueType := func(field int, typ, unit string) {
+		var v protoBuffer
+		v.int64(1, strs.index(typ))
+		v.int64(2, strs.index(unit))
+		b.message(field, &v)
+	}
+	valueType(1, "samples", "count")
+	valueType(1, "instructions", "count")
+	for _, sample := range p.samples {
+		var s protoBuffer
+		s.uint64s(1, sample.locations)
+		s.int64s(2, []int64{sample.count, sample.count * int64(p.interval)})
+		b.message(2, &s)
+	}
+	for loc, id := range p.locations {
+		var line protoBuffer
+		line.uint64(1, p.functions[loc.function])
+		line.int64(2, int64(loc.line))
+		var l protoBuffer
+		l.uint64(1, id)
+		l.message(4, &line)
+		b.message(4, &l)
+	}
+	for fn, id := range p.functions {
+		var f protoBuffer
+		f.uint64(1, id)
+		f.int64(2, strs.index(fn.name))
+		f.int64(3, strs.index(fn.name))
+		f.int64(4, strs.index(fn.filename))
+		f.int64(5, int64(fn.startLine))
+		b.message(5, &f)
+	}
+	b.int64(9, p.start.UnixNano())
+	b.int64(10, int64(p.duration))
+	valueType(11, "instructions", "count")
+	b.int64(12, int64(p.interval))
+	// string table goes last, after all strings are indexed
+	for _, s := range strs.strings {
+		b.string(6, s)
+	}
+
+	counter := &countWriter{w: w}
+	gz := gzip.NewWriter(counter)
+	if _, err := gz.Write(b.data); err != nil {
+		return counter.n, err
+	}
+	err := gz.Close()
+	return counter.n, err
+}
+
+type stringTable struct {
+	indexes map[string]int64
+	strings []string
+}
+
+func (t *stringTable) index(s string) int64 {
+	if i, ok := t.indexes[s]; ok {
+		return i
+	}
+	i := int64(len(t.strings))
+	t.indexes[s] = i
+	t.strings = append(t.strings, s)
+	return i
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// protoBuffer encodes protobuf messages
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		b.data = append(b.data, byte(v)|0x80)
+		v >>= 7
+	}
+	b.data = append(b.data, byte(v))
+}
+
+func (b *protoBuffer) tag(field int, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (b *protoBuffer) uint64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.tag(field, 0)
+	b.varint(v)
+}
+
+func (b *protoBuffer) int64(field int, v int64) {
+	b.uint64(field, uint64(v))
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.tag(field, 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *protoBuffer) string(field int, s string) {
+	b.bytes(field, []byte(s))
+}
+
+func (b *protoBuffer) message(field int, m *protoBuffer) {
+	b.bytes(field, m.data)
+}
+
+func (b *protoBuffer) uint64s(field int, vs []uint64) {
+	var packed protoBuffer
+	for _, v := range vs {
+		packed.varint(v)
+	}
+	b.bytes(field, packed.data)
+}
+
+func (b *protoBuffer) int64s(field int, vs []int64) {
+	var packed protoBuffer
+	for _, v := range vs {
+		packed.varint(uint64(v))
+	}
+	b.bytes(field, packed.data)
+}
@@ -0,0 +1,82 @@
+package lua
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	chunk, err := l.LoadString("profile.lua", `
+function hot(n)
+  local s = 0
+  for i = 1, n do
+    s = s + i % 7
+  end
+  return s
+end
+function cold()
+  return 1
+end
+for i = 1, 100 do
+  hot(10000)
+  cold()
+end
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chunk.Close()
+
+	if _, err := l.StartProfile(0); err == nil {
+		t.Fatalf("should fail")
+	}
+	p, err := l.StartProfile(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chunk.Run(); err != nil {
+		t.Fatal(err)
+	}
+	p.Stop()
+
+	names, counts := p.Top()
+	if len(names) == 0 || names[0] != "hot" || counts[0] == 0 {
+		t.Fatalf("bad top %v %v", names, counts)
+	}
+
+	buf := new(bytes.Buffer)
+	n, err := p.WriteTo(buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("bad write %d %v", n, err)
+	}
+	r, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"samples", "instructions", "hot", "profile.lua", "main chunk"} {
+		if !strings.Contains(string(data), s) {
+			t.Fatalf("%s not in profile", s)
+		}
+	}
+
+	// stopped
+	if _, err := l.Peval(`for i = 1, 100000 do end`); err != nil {
+		t.Fatal(err)
+	}
+	if names2, _ := p.Top(); len(names2) != len(names) {
+		t.Fatalf("sampled after stop")
+	}
+}
@@ -0,0 +1,143 @@
+package lua
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// Reloader loads modules from a fs.FS like AddModuleFS, and reloads the loaded ones when their files change
+type Reloader struct {
+	lua     *Lua
+	fsys    fs.FS
+	modules map[string]*reloadModule
+}
+
+type reloadModule struct {
+	path string
+	code string
+}
+
+// ReloadError reports modules failed to reload. their running versions are kept.
+type ReloadError struct {
+	Errors map[string]error // by module name
+}
+
+func (e *ReloadError) Error() string {
+	var names []string
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var msgs []string
+	for _, name := range names {
+		msgs = append(msgs, fmt.Sprintf("RELOAD ERROR: %s: %v", name, e.Errors[name]))
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// NewReloader makes require search modules in fsys after the default searchers
+func (l *Lua) NewReloader(fsys fs.FS) (*Reloader, error) {
+	r := &Reloader{
+		lua:     l,
+		fsys:    fsys,
+		modules: make(map[string]*reloadModule),
+	}
+	err := l.addSearcher(func(name string) (interface{}, string) {
+		modulePath, code, err := findModule(r.fsys, name)
+		if err != nil {
+			return nil, err.Error()
+		}
+		if l.refuseBinary && isBinaryChunk([]byte(code)) {
+			return nil, fmt.Sprintf("\n\tbinary chunk refused '%s'", modulePath)
+		}
+		r.modules[name] = &reloadModule{
+			path: modulePath,
+			code: code,
+		}
+		return code, modulePath
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetFS replaces the fs modules are loaded from, for example a new version of the scripts.
+// modules are reloaded by the next Reload.
+func (r *Reloader) SetFS(fsys fs.FS) {
+	r.fsys = fsys
+}
+
+// Reload runs modules whose code changed since they were loaded, and updates package.loaded in place.
+// when both the old and new module values are tables, the old table is updated to the new content,
+// so references held by other modules see the new version.
+// a module failed to compile or run keeps its running version and is reported in the returned *ReloadError.
+func (r *Reloader) Reload() (reloaded []string, err error) {
+	var names []string
+	for name := range r.modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	errs := make(map[string]error)
+	for _, name := range names {
+		module := r.modules[name]
+		modulePath, code, e := findModule(r.fsys, name)
+		if e != nil {
+			errs[name] = fmt.Errorf("module not found:%s", e.Error())
+			continue
+		}
+		if modulePath == module.path && code == module.code {
+			continue
+		}
+		if r.lua.refuseBinary && isBinaryChunk([]byte(code)) {
+			errs[name] = fmt.Errorf("binary chunk refused '%s'", modulePath)
+			continue
+		}
+		ret, e := r.lua.Peval(`
+		local loader, err = loadstring(code, '@' .. path)
+		if loader == nil then
+			return err
+		end
+		local ok, new = pcall(loader, name)
+		if not ok then
+			return tostring(new)
+		end
+		if new == nil then
+			new = true
+		end
+		local loaded = package.loaded
+		local old = loaded[name]
+		if type(old) == 'table' and type(new) == 'table' then
+			for k in pairs(old) do
+				if rawget(new, k) == nil then
+					old[k] = nil
+				end
+			end
+			for k, v in pairs(new) do
+				old[k] = v
+			end
+			setmetatable(old, getmetatable(new))
+		else
+			loaded[name] = new
+		end
+		`, "name", name, "path", modulePath, "code", code)
+		if e == nil && len(ret) > 0 && ret[0] != nil {
+			e = fmt.Errorf("%v", ret[0])
+		}
+		if e != nil {
+			errs[name] = e
+			continue
+		}
+		module.path = modulePath
+		module.code = code
+		reloaded = append(reloaded, name)
+	}
+	if len(errs) > 0 {
+		err = &ReloadError{
+			Errors: errs,
+		}
+	}
+	return
+}
@@ -0,0 +1,97 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestReloader(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	fsys := fstest.MapFS{
+		"greet.lua": &fstest.MapFile{
+			Data: []byte(`return {hello = function() return 'hello' end, old = true}`),
+		},
+		"answer.lua": &fstest.MapFile{
+			Data: []byte(`return 42`),
+		},
+	}
+	r, err := l.NewReloader(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Eval(`
+	greet = require('greet')
+	answer = require('answer')
+	`)
+
+	// not changed
+	reloaded, err := r.Reload()
+	if err != nil || len(reloaded) != 0 {
+		t.Fatalf("bad reload %v %v", reloaded, err)
+	}
+
+	// changed
+	fsys["greet.lua"] = &fstest.MapFile{
+		Data: []byte(`return {hello = function() return 'hi' end}`),
+	}
+	fsys["answer.lua"] = &fstest.MapFile{
+		Data: []byte(`return 84`),
+	}
+	reloaded, err = r.Reload()
+	if err != nil || len(reloaded) != 2 || reloaded[0] != "answer" || reloaded[1] != "greet" {
+		t.Fatalf("bad reload %v %v", reloaded, err)
+	}
+	ret, err := l.Peval(`return greet.hello(), greet.old, require('answer')`)
+	if err != nil || ret[0].(string) != "hi" || ret[1] != nil || ret[2].(float64) != 84 {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+
+	// compile error keeps running version
+	fsys["greet.lua"] = &fstest.MapFile{
+		Data: []byte("return {\nhello ="),
+	}
+	reloaded, err = r.Reload()
+	if err == nil || len(reloaded) != 0 || !strings.Contains(err.Error(), "greet.lua:2:") {
+		t.Fatalf("bad reload %v %v", reloaded, err)
+	}
+	if _, ok := err.(*ReloadError).Errors["greet"]; !ok {
+		t.Fatalf("error not reported by module name")
+	}
+	ret, err = l.Peval(`return require('greet').hello()`)
+	if err != nil || ret[0].(string) != "hi" {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+
+	// runtime error
+	fsys["greet.lua"] = &fstest.MapFile{
+		Data: []byte("error('greet error')"),
+	}
+	_, err = r.Reload()
+	if err == nil || !strings.Contains(err.Error(), "greet error") {
+		t.Fatalf("bad reload error %v", err)
+	}
+
+	// new fs version
+	r.SetFS(fstest.MapFS{
+		"greet.lua": &fstest.MapFile{
+			Data: []byte(`return {hello = function() return 'hey' end}`),
+		},
+	})
+	reloaded, err = r.Reload()
+	if err == nil || !strings.Contains(err.Error(), "answer: module not found") {
+		t.Fatalf("bad reload error %v", err)
+	}
+	if len(reloaded) != 1 || reloaded[0] != "greet" {
+		t.Fatalf("bad reload %v", reloaded)
+	}
+	ret, err = l.Peval(`return greet.hello()`)
+	if err != nil || ret[0].(string) != "hey" {
+		t.Fatalf("bad return %v %v", ret, err)
+	}
+}
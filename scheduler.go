@@ -0,0 +1,136 @@
+package lua
+
+/*
+#include <lua.h>
+*/
+import "C"
+import (
+	"fmt"
+	"reflect"
+)
+
+// Async wraps a go function so that calling it from a Scheduler coroutine
+// suspends the coroutine while the function runs on another goroutine.
+// The coroutine is resumed with the function's results when it returns.
+func Async(fn interface{}) *Function {
+	f := asFunction(fn)
+	f.async = true
+	return f
+}
+
+type asyncCall struct {
+	function *_Function
+	args     []reflect.Value
+	thread   *Thread
+	returns  []reflect.Value
+	err      error
+}
+
+// Scheduler runs coroutines cooperatively in one lua vm.
+// A coroutine runs until it yields or calls an async function, then the next ready one runs.
+// The lua vm is only used by the goroutine calling Run.
+type Scheduler struct {
+	lua     *Lua
+	ready   []*schedulerTask
+	done    chan *asyncCall
+	waiting int
+}
+
+type schedulerTask struct {
+	thread *Thread
+	args   []interface{}
+}
+
+// NewScheduler creates a scheduler for coroutines of the lua vm
+func (l *Lua) NewScheduler() *Scheduler {
+	return &Scheduler{
+		lua:  l,
+		done: make(chan *asyncCall),
+	}
+}
+
+// Spawn creates a coroutine running the lua function named fullname with args.
+// It starts when Run is called.
+func (s *Scheduler) Spawn(fullname string, args ...interface{}) error {
+	thread, err := s.lua.NewThread(fullname)
+	if err != nil {
+		return err
+	}
+	if s.lua.asyncThreads == nil {
+		s.lua.asyncThreads = make(map[*C.lua_State]*Thread)
+	}
+	s.lua.asyncThreads[thread.lua.State] = thread
+	s.ready = append(s.ready, &schedulerTask{
+		thread: thread,
+		args:   args,
+	})
+	return nil
+}
+
+// Run runs coroutines until all of them are dead. returns the first error raised by coroutines.
+func (s *Scheduler) Run() (err error) {
+	for len(s.ready) > 0 || s.waiting > 0 {
+		if len(s.ready) == 0 {
+			// wait for async functions
+			call := <-s.done
+			s.waiting--
+			if call.err != nil {
+				s.exit(call.thread)
+				if err == nil {
+					err = call.err
+				}
+				continue
+			}
+			args := make([]interface{}, 0, len(call.returns))
+			for _, v := range call.returns {
+				args = append(args, v.Interface())
+			}
+			s.ready = append(s.ready, &schedulerTask{
+				thread: call.thread,
+				args:   args,
+			})
+			continue
+		}
+		task := s.ready[0]
+		s.ready = s.ready[1:]
+		if e := s.step(task); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (s *Scheduler) step(task *schedulerTask) error {
+	thread := task.thread
+	_, status, err := thread.Resume(task.args...)
+	if err != nil || status == ThreadDead {
+		s.exit(thread)
+		return err
+	}
+	call := thread.pending
+	if call == nil {
+		// yielded, run again after other ready coroutines
+		s.ready = append(s.ready, &schedulerTask{
+			thread: thread,
+		})
+		return nil
+	}
+	thread.pending = nil
+	call.thread = thread
+	s.waiting++
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				call.err = fmt.Errorf("CALL ERROR: async function panic: %s: %v", call.function.name, p)
+			}
+			s.done <- call
+		}()
+		call.returns = call.function.funcValue.Call(call.args)
+	}()
+	return nil
+}
+
+func (s *Scheduler) exit(thread *Thread) {
+	delete(s.lua.asyncThreads, thread.lua.State)
+	thread.Close()
+}
@@ -0,0 +1,93 @@
+package lua
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestScheduler(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// both coroutines must be suspended before any fetch returns
+	var wg sync.WaitGroup
+	wg.Add(2)
+	l.Set("fetch", Async(func(key string) (string, int) {
+		wg.Done()
+		wg.Wait()
+		return key + "!", len(key)
+	}))
+	results := make(map[string]string)
+	l.Set("report", func(key, value string, n int) {
+		if n != len(key) {
+			t.Fatalf("bad length")
+		}
+		results[key] = value
+	})
+	l.Eval(`
+	function job(key)
+		local value, n = fetch(key)
+		coroutine.yield()
+		report(key, value, n)
+	end
+	`)
+	s := l.NewScheduler()
+	if err := s.Spawn("job", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Spawn("job", "quux"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if results["foo"] != "foo!" || results["quux"] != "quux!" {
+		t.Fatalf("bad results %v", results)
+	}
+
+	// error
+	l.Eval(`function bad() fetch('foo') error('job error') end`)
+	wg.Add(1)
+	s = l.NewScheduler()
+	s.Spawn("bad")
+	err = s.Run()
+	if err == nil || !strings.Contains(err.Error(), "job error") {
+		t.Fatalf("allowing error or error %v", err)
+	}
+
+	// panic
+	l.Set("explode", Async(func() {
+		panic("boom")
+	}))
+	l.Eval(`function boom() explode() end`)
+	s = l.NewScheduler()
+	s.Spawn("boom")
+	err = s.Run()
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("allowing panic or error %v", err)
+	}
+
+	// bad function
+	if err := s.Spawn("none"); err == nil {
+		t.Fatalf("allowing bad function")
+	}
+
+	// not in scheduler
+	_, err = l.Peval(`explode()`)
+	if err == nil || !strings.Contains(err.Error(), "scheduler coroutine") {
+		t.Fatalf("allowing async call outside scheduler or error %v", err)
+	}
+
+	// context functions can't run on other goroutines
+	if err := l.Pset("ctx", Async(func(c *CallContext) int { return 0 })); err == nil {
+		t.Fatalf("allowing async context function")
+	}
+	err = l.Pset("info", Async(func(c *CallInfo, n int) int { return n }))
+	if err == nil || !strings.Contains(err.Error(), "cannot take *CallInfo") {
+		t.Fatalf("allowing async call info function or error %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package lua
+
+/*
+#include <lua.h>
+#include <lauxlib.h>
+*/
+import "C"
+import "fmt"
+
+// Snapshot records the globals, and optionally package.loaded, of a lua vm.
+// it's shallow: tables reachable from globals are not copied, so changes inside them are not restored.
+type Snapshot struct {
+	lua        *Lua
+	ref        C.int
+	withLoaded bool
+}
+
+// Snapshot records the current globals. package.loaded is also recorded if withLoaded is true.
+func (l *Lua) Snapshot(withLoaded bool) (*Snapshot, error) {
+	defer C.lua_settop(l.State, 0)
+	var loaded C.int
+	if withLoaded {
+		var err error
+		if loaded, err = l.pushLoaded(); err != nil {
+			return nil, err
+		}
+	}
+	C.lua_createtable(l.State, 2, 0)
+	snapshot := C.lua_gettop(l.State)
+	C.lua_createtable(l.State, 0, 0)
+	l.copyTable(C.LUA_GLOBALSINDEX, C.lua_gettop(l.State))
+	C.lua_rawseti(l.State, snapshot, 1)
+	if withLoaded {
+		C.lua_createtable(l.State, 0, 0)
+		l.copyTable(loaded, C.lua_gettop(l.State))
+		C.lua_rawseti(l.State, snapshot, 2)
+	}
+	C.lua_settop(l.State, snapshot)
+	return &Snapshot{
+		lua:        l,
+		ref:        C.luaL_ref(l.State, C.LUA_REGISTRYINDEX),
+		withLoaded: withLoaded,
+	}, nil
+}
+
+// Restore removes globals added since the snapshot was taken, and resets changed ones.
+// package.loaded is restored the same way if it was recorded.
+// the snapshot can be restored many times.
+func (l *Lua) Restore(s *Snapshot) error {
+	if s.ref == C.LUA_NOREF {
+		return fmt.Errorf("snapshot is closed")
+	}
+	defer C.lua_settop(l.State, 0)
+	C.lua_rawgeti(l.State, C.LUA_REGISTRYINDEX, s.ref)
+	snapshot := C.lua_gettop(l.State)
+	C.lua_rawgeti(l.State, snapshot, 1)
+	globals := C.lua_gettop(l.State)
+	l.removeMissing(C.LUA_GLOBALSINDEX, globals)
+	l.copyTable(globals, C.LUA_GLOBALSINDEX)
+	if s.withLoaded {
+		loaded, err := l.pushLoaded()
+		if err != nil {
+			return err
+		}
+		C.lua_rawgeti(l.State, snapshot, 2)
+		recorded := C.lua_gettop(l.State)
+		l.removeMissing(loaded, recorded)
+		l.copyTable(recorded, loaded)
+	}
+	return nil
+}
+
+// Close releases the snapshot
+func (s *Snapshot) Close() {
+	if s.ref == C.LUA_NOREF {
+		return
+	}
+	C.luaL_unref(s.lua.State, C.LUA_REGISTRYINDEX, s.ref)
+	s.ref = C.LUA_NOREF
+}
+
+// pushLoaded pushes package.loaded and returns its index
+func (l *Lua) pushLoaded() (C.int, error) {
+	C.lua_getfield(l.State, C.LUA_GLOBALSINDEX, cstr("package"))
+	if C.lua_type(l.State, -1) != C.LUA_TTABLE {
+		return 0, fmt.Errorf("package library not loaded")
+	}
+	C.lua_getfield(l.State, -1, cstr("loaded"))
+	if C.lua_type(l.State, -1) != C.LUA_TTABLE {
+		return 0, fmt.Errorf("package.loaded is not a table")
+	}
+	return C.lua_gettop(l.State), nil
+}
+
+// copyTable copies fields of the table at src to the table at dst, ignoring metatables
+func (l *Lua) copyTable(src, dst C.int) {
+	C.lua_pushnil(l.State)
+	for C.lua_next(l.State, src) != 0 {
+		C.lua_pushvalue(l.State, -2)
+		C.lua_insert(l.State, -2)
+		C.lua_rawset(l.State, dst)
+	}
+}
+
+// removeMissing removes fields of the table at t which are not in the table at keep
+func (l *Lua) removeMissing(t, keep C.int) {
+	C.lua_pushnil(l.State)
+	for C.lua_next(l.State, t) != 0 {
+		C.lua_settop(l.State, -2)
+		C.lua_pushvalue(l.State, -1)
+		C.lua_rawget(l.State, keep)
+		missing := C.lua_type(l.State, -1) == C.LUA_TNIL
+		C.lua_settop(l.State, -2)
+		if missing {
+			// assigning nil to existing fields is allowed during traversal
+			C.lua_pushvalue(l.State, -1)
+			C.lua_pushnil(l.State)
+			C.lua_rawset(l.State, t)
+		}
+	}
+}
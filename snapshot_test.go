@@ -0,0 +1,69 @@
+package lua
+
+import "testing"
+
+func TestSnapshot(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.Set("limit", 10)
+	l.Set("conf.name", "foo")
+	l.RegisterModule("mod", func(*Lua) map[string]interface{} {
+		return map[string]interface{}{"n": 1}
+	})
+	snap, err := l.Snapshot(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+
+	for i := 0; i < 2; i++ {
+		_, err = l.Peval(`
+		limit = 20
+		leaked = true
+		print = nil
+		conf = {}
+		require('mod')
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Restore(snap); err != nil {
+			t.Fatal(err)
+		}
+		if l.Get("limit").(float64) != 10 || l.Has("leaked") || !l.Has("print") {
+			t.Fatalf("globals not restored")
+		}
+		// shallow
+		if l.Get("conf.name").(string) != "foo" {
+			t.Fatalf("table not restored")
+		}
+		ret, err := l.Peval(`return package.loaded.mod`)
+		if err != nil || ret[0] != nil {
+			t.Fatalf("package.loaded not restored %v %v", ret, err)
+		}
+	}
+
+	// without package.loaded
+	snap2, err := l.Snapshot(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Peval(`require('mod') foo = 1`); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Restore(snap2); err != nil {
+		t.Fatal(err)
+	}
+	ret, err := l.Peval(`return foo, package.loaded.mod ~= nil`)
+	if err != nil || ret[0] != nil || ret[1] != true {
+		t.Fatalf("bad restore %v %v", ret, err)
+	}
+	snap2.Close()
+	if err := l.Restore(snap2); err == nil {
+		t.Fatalf("should fail")
+	}
+}
@@ -0,0 +1,5 @@
+local n = N
+if n > 1 then
+  error('too large')
+end
+return n
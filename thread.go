@@ -0,0 +1,119 @@
+package lua
+
+/*
+#include <lua.h>
+#include <lauxlib.h>
+*/
+import "C"
+import "fmt"
+
+// ThreadStatus is the status of a lua coroutine
+type ThreadStatus int
+
+// thread statuses
+const (
+	ThreadSuspended ThreadStatus = iota // not started or yielded
+	ThreadRunning
+	ThreadDead // returned or raised an error
+)
+
+func (s ThreadStatus) String() string {
+	switch s {
+	case ThreadSuspended:
+		return "suspended"
+	case ThreadRunning:
+		return "running"
+	case ThreadDead:
+		return "dead"
+	}
+	return fmt.Sprintf("ThreadStatus(%d)", int(s))
+}
+
+// Yield is returned by go functions to suspend the calling coroutine.
+// The values are returned by Resume, and the arguments of the next Resume
+// become the results of the go function call in lua.
+type Yield []interface{}
+
+// Thread wraps a lua coroutine
+type Thread struct {
+	lua    *Lua // view of the coroutine state
+	ref    C.int
+	status ThreadStatus
+	// set when the coroutine is suspended by an async function
+	pending *asyncCall
+	// receiver of method, passed by the first Resume
+	nself int
+}
+
+// NewThread creates a coroutine running the lua function named fullname, which can be a method like in Pcall.
+// The function is started by the first Resume.
+func (l *Lua) NewThread(fullname string) (*Thread, error) {
+	defer C.lua_settop(l.State, 0)
+	state := C.lua_newthread(l.State)
+	nself, err := l.pushFunction(fullname)
+	if err != nil {
+		return nil, err
+	}
+	C.lua_xmove(l.State, state, C.int(1+nself))
+	// keep the coroutine from being collected
+	ref := C.luaL_ref(l.State, C.LUA_REGISTRYINDEX)
+	return &Thread{
+		lua:   l.at(state),
+		ref:   ref,
+		nself: nself,
+	}, nil
+}
+
+// Status returns the status of the coroutine
+func (t *Thread) Status() ThreadStatus {
+	return t.status
+}
+
+// Resume starts or continues the coroutine. returns values yielded or returned by the coroutine.
+func (t *Thread) Resume(args ...interface{}) (returns []interface{}, status ThreadStatus, err error) {
+	switch t.status {
+	case ThreadRunning:
+		return nil, t.status, fmt.Errorf("cannot resume running coroutine")
+	case ThreadDead:
+		return nil, t.status, fmt.Errorf("cannot resume dead coroutine")
+	}
+	state := t.lua.State
+	defer C.lua_settop(state, 0)
+	for _, arg := range args {
+		if err := t.lua.pushGoValue(arg, ""); err != nil {
+			return nil, t.status, err
+		}
+	}
+	// call
+	main := t.lua.mainLua()
+	main.err = nil
+	t.status = ThreadRunning
+	ret := C.lua_resume(state, C.int(t.nself+len(args)))
+	t.nself = 0
+	switch ret {
+	case 0:
+		t.status = ThreadDead
+	case C.LUA_YIELD:
+		t.status = ThreadSuspended
+	default:
+		// error occured
+		t.status = ThreadDead
+		return nil, t.status, fmt.Errorf("CALL ERROR: %s", C.GoString(C.lua_tolstring(state, -1, nil)))
+	}
+	if main.err != nil { // error raise by invokeGoFunc
+		return nil, t.status, main.err
+	}
+	// yielded or returned values
+	returns, err = t.lua.returns(0)
+	return returns, t.status, err
+}
+
+// Close releases the coroutine
+func (t *Thread) Close() {
+	if t.ref == C.LUA_NOREF {
+		return
+	}
+	C.luaL_unref(t.lua.mainLua().State, C.LUA_REGISTRYINDEX, t.ref)
+	t.ref = C.LUA_NOREF
+	t.status = ThreadDead
+}
@@ -0,0 +1,120 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestThread(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.Eval(`
+	function gen(n)
+		for i = 1, n do
+			local got = coroutine.yield(i)
+			if got ~= i * 2 then error('bad resume value') end
+		end
+		return 'done'
+	end
+	`)
+	thread, err := l.NewThread("gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer thread.Close()
+	if thread.Status() != ThreadSuspended {
+		t.Fatalf("new thread is not suspended")
+	}
+	ret, status, err := thread.Resume(3)
+	if err != nil || status != ThreadSuspended || ret[0].(float64) != 1 {
+		t.Fatalf("bad first resume %v %v %v", ret, status, err)
+	}
+	for i := 1; i < 3; i++ {
+		ret, status, err = thread.Resume(i * 2)
+		if err != nil || status != ThreadSuspended || ret[0].(float64) != float64(i+1) {
+			t.Fatalf("bad resume %v %v %v", ret, status, err)
+		}
+	}
+	ret, status, err = thread.Resume(6)
+	if err != nil || status != ThreadDead || ret[0].(string) != "done" {
+		t.Fatalf("bad last resume %v %v %v", ret, status, err)
+	}
+	_, _, err = thread.Resume()
+	if err == nil || !strings.Contains(err.Error(), "dead coroutine") {
+		t.Fatalf("allowing resuming dead coroutine")
+	}
+
+	// error
+	l.Eval(`function fail() error('thread error') end`)
+	thread, err = l.NewThread("fail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, status, err = thread.Resume()
+	if err == nil || !strings.Contains(err.Error(), "thread error") || status != ThreadDead {
+		t.Fatalf("allowing error or error %v", err)
+	}
+	thread.Close()
+
+	// bad function
+	_, err = l.NewThread("none")
+	if err == nil {
+		t.Fatalf("allowing bad function")
+	}
+}
+
+func TestThreadGoYield(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.Set("wait", func(what string) Yield {
+		return Yield{what}
+	})
+	l.Set("add", func(a, b int) int {
+		return a + b
+	})
+	l.Eval(`
+	function worker()
+		local data = wait('read')
+		return add(data, 1)
+	end
+	`)
+	thread, err := l.NewThread("worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer thread.Close()
+	ret, status, err := thread.Resume()
+	if err != nil || status != ThreadSuspended || ret[0].(string) != "read" {
+		t.Fatalf("bad yield %v %v %v", ret, status, err)
+	}
+	ret, status, err = thread.Resume(41)
+	if err != nil || status != ThreadDead || ret[0].(float64) != 42 {
+		t.Fatalf("bad return %v %v %v", ret, status, err)
+	}
+
+	// go function error in coroutine
+	thread, err = l.NewThread("worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer thread.Close()
+	thread.Resume()
+	_, _, err = thread.Resume("foo")
+	if err == nil || !strings.Contains(err.Error(), "not an integer") {
+		t.Fatalf("allowing bad argument or error %v", err)
+	}
+
+	// yield from main thread
+	_, err = l.Peval(`wait('foo')`)
+	if err == nil {
+		t.Fatalf("allowing yield across C-call boundary")
+	}
+}